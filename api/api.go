@@ -0,0 +1,345 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/sapcc/promdump/store"
+)
+
+// API serves a Store through the subset of the Prometheus HTTP API used by
+// Grafana and promtool.
+type API struct {
+	Store  *store.Store
+	Engine *promql.Engine
+	// Metadata is served for the metrics of the store, others are reported with type unknown.
+	Metadata map[string][]Metadata
+	Version  string
+}
+
+type response struct {
+	Status    string      `json:"status"`
+	Data      interface{} `json:"data,omitempty"`
+	ErrorType string      `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Warnings  []string    `json:"warnings,omitempty"`
+}
+
+type apiError struct {
+	typ    string
+	status int
+	err    error
+}
+
+func badData(err error) *apiError {
+	return &apiError{typ: "bad_data", status: http.StatusBadRequest, err: err}
+}
+
+type queryData struct {
+	ResultType parser.ValueType `json:"resultType"`
+	Result     parser.Value     `json:"result"`
+}
+
+// Metadata is a variant of the type, help and unit of a metric.
+type Metadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+var (
+	minTime = time.Unix(math.MinInt64/1000+62135596801, 0).UTC()
+	maxTime = time.Unix(math.MaxInt64/1000-62135596801, 999999999).UTC()
+)
+
+// Handler returns the routes below /api/v1.
+func (a *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/query", a.wrap(a.query))
+	mux.HandleFunc("/api/v1/query_range", a.wrap(a.queryRange))
+	mux.HandleFunc("/api/v1/series", a.wrap(a.series))
+	mux.HandleFunc("/api/v1/labels", a.wrap(a.labelNames))
+	mux.HandleFunc("/api/v1/label/", a.wrap(a.labelValues))
+	mux.HandleFunc("/api/v1/metadata", a.wrap(a.metadata))
+	mux.HandleFunc("/api/v1/status/buildinfo", a.wrap(a.buildinfo))
+	return mux
+}
+
+func (a *API) wrap(fn func(r *http.Request) (interface{}, []string, *apiError)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := r.ParseForm(); err != nil {
+			writeResponse(w, http.StatusBadRequest, response{Status: "error", ErrorType: "bad_data", Error: err.Error()})
+			return
+		}
+		data, warnings, apiErr := fn(r)
+		if apiErr != nil {
+			writeResponse(w, apiErr.status, response{Status: "error", ErrorType: apiErr.typ, Error: apiErr.err.Error()})
+			return
+		}
+		writeResponse(w, http.StatusOK, response{Status: "success", Data: data, Warnings: warnings})
+	}
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp response) {
+	marshaled, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(marshaled) //nolint:errcheck
+}
+
+func (a *API) query(r *http.Request) (interface{}, []string, *apiError) {
+	ts, err := parseTimeParam(r, "time", time.Now())
+	if err != nil {
+		return nil, nil, badData(err)
+	}
+	q, err := a.Engine.NewInstantQuery(r.Context(), a.Store, nil, r.FormValue("query"), ts)
+	if err != nil {
+		return nil, nil, badData(err)
+	}
+	return a.exec(r, q)
+}
+
+func (a *API) queryRange(r *http.Request) (interface{}, []string, *apiError) {
+	start, err := parseTime(r.FormValue("start"))
+	if err != nil {
+		return nil, nil, badData(fmt.Errorf("invalid parameter \"start\": %w", err))
+	}
+	end, err := parseTime(r.FormValue("end"))
+	if err != nil {
+		return nil, nil, badData(fmt.Errorf("invalid parameter \"end\": %w", err))
+	}
+	if end.Before(start) {
+		return nil, nil, badData(fmt.Errorf("end timestamp must not be before start time"))
+	}
+	step, err := parseDuration(r.FormValue("step"))
+	if err != nil {
+		return nil, nil, badData(fmt.Errorf("invalid parameter \"step\": %w", err))
+	}
+	if step <= 0 {
+		return nil, nil, badData(fmt.Errorf("zero or negative query resolution step widths are not accepted"))
+	}
+	if end.Sub(start)/step > 11000 {
+		return nil, nil, badData(fmt.Errorf("exceeded maximum resolution of 11,000 points per timeseries"))
+	}
+	q, err := a.Engine.NewRangeQuery(r.Context(), a.Store, nil, r.FormValue("query"), start, end, step)
+	if err != nil {
+		return nil, nil, badData(err)
+	}
+	return a.exec(r, q)
+}
+
+func (a *API) exec(r *http.Request, q promql.Query) (interface{}, []string, *apiError) {
+	defer q.Close()
+	res := q.Exec(r.Context())
+	if res.Err != nil {
+		return nil, nil, &apiError{typ: "execution", status: http.StatusUnprocessableEntity, err: res.Err}
+	}
+	return queryData{ResultType: res.Value.Type(), Result: res.Value}, warningStrings(res.Warnings), nil
+}
+
+func (a *API) series(r *http.Request) (interface{}, []string, *apiError) {
+	matcherSets, err := parseMatchers(r.Form["match[]"])
+	if err != nil {
+		return nil, nil, badData(err)
+	}
+	if len(matcherSets) == 0 {
+		return nil, nil, badData(fmt.Errorf("no match[] parameter provided"))
+	}
+	q, apiErr := a.querier(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+	defer q.Close()
+	seen := make(map[string]struct{})
+	result := make([]labels.Labels, 0)
+	for _, matchers := range matcherSets {
+		set := q.Select(true, nil, matchers...)
+		for set.Next() {
+			lset := set.At().Labels()
+			if _, ok := seen[lset.String()]; !ok {
+				seen[lset.String()] = struct{}{}
+				result = append(result, lset)
+			}
+		}
+	}
+	return result, nil, nil
+}
+
+func (a *API) labelNames(r *http.Request) (interface{}, []string, *apiError) {
+	return a.labelQuery(r, func(q storage.Querier, matchers []*labels.Matcher) ([]string, storage.Warnings, error) {
+		return q.LabelNames(matchers...)
+	})
+}
+
+func (a *API) labelValues(r *http.Request) (interface{}, []string, *apiError) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/label/"), "/values")
+	if !ok || !prommodel.LabelNameRE.MatchString(name) {
+		return nil, nil, badData(fmt.Errorf("invalid label name: %q", name))
+	}
+	return a.labelQuery(r, func(q storage.Querier, matchers []*labels.Matcher) ([]string, storage.Warnings, error) {
+		return q.LabelValues(name, matchers...)
+	})
+}
+
+// labelQuery unions the results of fn for all match[] parameters.
+func (a *API) labelQuery(r *http.Request, fn func(storage.Querier, []*labels.Matcher) ([]string, storage.Warnings, error)) (interface{}, []string, *apiError) {
+	matcherSets, err := parseMatchers(r.Form["match[]"])
+	if err != nil {
+		return nil, nil, badData(err)
+	}
+	if len(matcherSets) == 0 {
+		matcherSets = [][]*labels.Matcher{nil}
+	}
+	q, apiErr := a.querier(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+	defer q.Close()
+	seen := make(map[string]struct{})
+	result := make([]string, 0)
+	for _, matchers := range matcherSets {
+		values, _, err := fn(q, matchers)
+		if err != nil {
+			return nil, nil, &apiError{typ: "execution", status: http.StatusUnprocessableEntity, err: err}
+		}
+		for _, value := range values {
+			if _, ok := seen[value]; !ok {
+				seen[value] = struct{}{}
+				result = append(result, value)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result, nil, nil
+}
+
+func (a *API) querier(r *http.Request) (storage.Querier, *apiError) {
+	start, err := parseTimeParam(r, "start", minTime)
+	if err != nil {
+		return nil, badData(err)
+	}
+	end, err := parseTimeParam(r, "end", maxTime)
+	if err != nil {
+		return nil, badData(err)
+	}
+	q, err := a.Store.Querier(r.Context(), start.UnixMilli(), end.UnixMilli())
+	if err != nil {
+		return nil, &apiError{typ: "execution", status: http.StatusUnprocessableEntity, err: err}
+	}
+	return q, nil
+}
+
+// metadata is not part of dumps, it is taken from the metrics catalogs
+// given to serve. Other metrics are reported with type unknown.
+func (a *API) metadata(r *http.Request) (interface{}, []string, *apiError) {
+	limit := -1
+	if s := r.FormValue("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil {
+			return nil, nil, badData(fmt.Errorf("limit must be a number"))
+		}
+	}
+	metric := r.FormValue("metric")
+	result := make(map[string][]Metadata)
+	for _, name := range a.Store.MetricNames() {
+		if limit >= 0 && len(result) >= limit {
+			break
+		}
+		if metric != "" && metric != name {
+			continue
+		}
+		if variants, ok := a.Metadata[name]; ok {
+			result[name] = variants
+		} else {
+			result[name] = []Metadata{{Type: "unknown"}}
+		}
+	}
+	return result, nil, nil
+}
+
+func (a *API) buildinfo(r *http.Request) (interface{}, []string, *apiError) {
+	return map[string]string{"version": a.Version, "application": "promdump"}, nil, nil
+}
+
+func parseMatchers(specs []string) ([][]*labels.Matcher, error) {
+	matcherSets := make([][]*labels.Matcher, 0, len(specs))
+	for _, spec := range specs {
+		matchers, err := parser.ParseMetricSelector(spec)
+		if err != nil {
+			return nil, err
+		}
+		matcherSets = append(matcherSets, matchers)
+	}
+	return matcherSets, nil
+}
+
+func parseTimeParam(r *http.Request, name string, fallback time.Time) (time.Time, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := parseTime(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid parameter %q: %w", name, err)
+	}
+	return parsed, nil
+}
+
+// parseTime accepts unix timestamps with fractions and RFC 3339 like Prometheus.
+func parseTime(s string) (time.Time, error) {
+	if t, err := strconv.ParseFloat(s, 64); err == nil {
+		seconds, fraction := math.Modf(t)
+		return time.Unix(int64(seconds), int64(math.Round(fraction*1000))*int64(time.Millisecond)).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", s)
+}
+
+// parseDuration accepts seconds with fractions and Prometheus durations like 5m.
+func parseDuration(s string) (time.Duration, error) {
+	if d, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(d * float64(time.Second)), nil
+	}
+	if d, err := prommodel.ParseDuration(s); err == nil {
+		return time.Duration(d), nil
+	}
+	return 0, fmt.Errorf("cannot parse %q to a valid duration", s)
+}
+
+func warningStrings(warnings storage.Warnings) []string {
+	result := make([]string, 0, len(warnings))
+	for _, warning := range warnings {
+		result = append(result, warning.Error())
+	}
+	return result
+}
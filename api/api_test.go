@@ -0,0 +1,67 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+	"github.com/sapcc/promdump/store"
+)
+
+func TestMetadata(t *testing.T) {
+	samples := store.New()
+	samples.Add(prommodel.Matrix{
+		{Metric: prommodel.Metric{prommodel.MetricNameLabel: "up"}, Values: []prommodel.SamplePair{{Timestamp: 1000, Value: 1}}},
+		{Metric: prommodel.Metric{prommodel.MetricNameLabel: "job:up:sum"}, Values: []prommodel.SamplePair{{Timestamp: 1000, Value: 1}}},
+	})
+	samples.Sort()
+	a := &API{
+		Store:    samples,
+		Engine:   store.NewEngine(time.Minute),
+		Metadata: map[string][]Metadata{"up": {{Type: "gauge", Help: "Whether the target is up."}}, "other": {{Type: "counter"}}},
+	}
+	server := httptest.NewServer(a.Handler())
+	defer server.Close()
+
+	cases := map[string]map[string][]Metadata{
+		"": {
+			"up":         {{Type: "gauge", Help: "Whether the target is up."}},
+			"job:up:sum": {{Type: "unknown"}},
+		},
+		"?metric=up": {"up": {{Type: "gauge", Help: "Whether the target is up."}}},
+	}
+	for params, expected := range cases {
+		res, err := server.Client().Get(server.URL + "/api/v1/metadata" + params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Status string                `json:"status"`
+			Data   map[string][]Metadata `json:"data"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body.Status != "success" || !reflect.DeepEqual(body.Data, expected) {
+			t.Errorf("%q: got %+v, want %v", params, body, expected)
+		}
+	}
+}
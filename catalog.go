@@ -0,0 +1,183 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+	"github.com/sapcc/promdump/client"
+	"github.com/sapcc/promdump/manifest"
+	"github.com/sapcc/promdump/model"
+	"github.com/sapcc/promdump/query"
+	"github.com/urfave/cli/v2"
+)
+
+// catalog writes catalog data like metrics or targets, which has no samples
+// to bundle or partition, either through a sink or as text table to a terminal.
+type catalog struct {
+	output outputConfig
+	sink   *sink
+	text   io.Writer
+}
+
+// openCatalog prints text tables to w if asText is set and opens a sink otherwise.
+func (cfg *outputConfig) openCatalog(w io.Writer, asText bool) (*catalog, error) {
+	if asText {
+		if !cfg.toStdout() {
+			return nil, fmt.Errorf("--table prints to the terminal and cannot be combined with --output")
+		}
+		return &catalog{output: *cfg, text: w}, nil
+	}
+	if cfg.partitioned() || cfg.bundle != "" {
+		return nil, fmt.Errorf("--bundle and --partition-by are only supported for samples")
+	}
+	s, err := cfg.openSink()
+	if err != nil {
+		return nil, err
+	}
+	return &catalog{output: *cfg, sink: s}, nil
+}
+
+func (c *catalog) asText() bool {
+	return c.text != nil
+}
+
+func (c *catalog) abort() {
+	if c.sink != nil {
+		c.sink.abort()
+	}
+}
+
+// write writes table as text or with the manifest of a catalog of targets.
+func (c *catalog) write(table *model.Table, targets []query.Target) error {
+	if c.asText() {
+		return table.WriteText(c.text)
+	}
+	return c.sink.writeTable(table, c.manifest(targets))
+}
+
+// commit writes catalog data marshaled by the caller, e.g. in the nested layout.
+func (c *catalog) commit(write func(w io.Writer) error, rows int, targets []query.Target) error {
+	if err := write(c.sink.file); err != nil {
+		return err
+	}
+	m := c.manifest(targets)
+	m.Rows = rows
+	return c.sink.commit(m)
+}
+
+func (c *catalog) manifest(targets []query.Target) manifest.Manifest {
+	m := manifest.FromTargets(targets)
+	c.output.describe(&m)
+	return m
+}
+
+// catalogConfig is shared by the commands writing catalogs of prometheus api endpoints.
+type catalogConfig struct {
+	http     client.HTTPConfig
+	output   outputConfig
+	promURLs []query.Target
+	table    bool
+}
+
+// open checks the layout and opens the output before anything is fetched.
+func (cfg *catalogConfig) open(w io.Writer, command string) (*catalog, *http.Client, error) {
+	if model.Layout(cfg.output.layout) != model.LayoutFlat {
+		return nil, nil, fmt.Errorf("%s only support the flat layout", command)
+	}
+	httpClient, err := client.MakeHTTPClient(cfg.http)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := cfg.output.openCatalog(w, cfg.table)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, &httpClient, nil
+}
+
+// catalogCommandFlags are the flags of the commands querying catalogs of
+// prometheis, with the command specific flags in between, see catalogFlags.
+func catalogCommandFlags(flags ...cli.Flag) []cli.Flag {
+	return append(append([]cli.Flag{
+		&cli.StringSliceFlag{
+			Name:     "url",
+			Required: true,
+			Usage:    "prometheis to query, optionally as ALIAS=URL",
+			Aliases:  []string{"u"},
+		},
+	}, flags...),
+		&cli.BoolFlag{
+			Name:  "table",
+			Usage: "print a table instead of writing --format",
+		},
+	)
+}
+
+// sinceFlag is the window of catalog commands looking up series, see catalogWindow.
+func sinceFlag(usage string) cli.Flag {
+	return &cli.DurationFlag{
+		Name:  "since",
+		Value: 24 * time.Hour,
+		Usage: usage + " over this window before --end, 0 means all of history, an explicit --start overrides it",
+	}
+}
+
+// catalogWindow is --since before --end, or --start to --end if --start is set explicitly.
+func catalogWindow(ctx *cli.Context) (time.Time, time.Time) {
+	end := *ctx.Timestamp("end")
+	if ctx.IsSet("start") {
+		return *ctx.Timestamp("start"), end
+	}
+	if since := ctx.Duration("since"); since > 0 {
+		return end.Add(-since), end
+	}
+	return time.UnixMilli(0), end
+}
+
+func catalogFlags(ctx *cli.Context) catalogConfig {
+	return catalogConfig{
+		http:     httpConfig(ctx),
+		output:   outputFlags(ctx),
+		promURLs: query.ParseTargets(ctx.StringSlice("url")),
+		table:    ctx.Bool("table"),
+	}
+}
+
+func (cfg *catalogConfig) columns(columns ...string) []string {
+	if len(cfg.promURLs) > 1 {
+		return append([]string{"server"}, columns...)
+	}
+	return columns
+}
+
+func labelMap(labels prommodel.LabelSet) map[string]string {
+	result := make(map[string]string, len(labels))
+	for name, value := range labels {
+		result[string(name)] = string(value)
+	}
+	return result
+}
+
+// formatTime writes catalog timestamps as RFC 3339, zero times are left empty.
+func formatTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
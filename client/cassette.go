@@ -0,0 +1,242 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+type cassetteRequest struct {
+	Method string     `json:"method"`
+	URL    string     `json:"url"`
+	Params url.Values `json:"params,omitempty"`
+}
+
+type cassetteResponse struct {
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header"`
+	Body         string      `json:"body"`
+	BodyEncoding string      `json:"bodyEncoding,omitempty"`
+}
+
+type cassetteEntry struct {
+	Request  cassetteRequest  `json:"request"`
+	Response cassetteResponse `json:"response"`
+}
+
+// cassetteTimeParams are normalized, since clients format the same time differently.
+var cassetteTimeParams = []string{"start", "end", "time"}
+
+// normalizeRequest reduces req to method, url without query and the merged
+// query and form parameters, so that the order of parameters does not matter.
+// The body is returned as well, req itself is left untouched unless its body
+// cannot be replayed through GetBody.
+func normalizeRequest(req *http.Request) (cassetteRequest, []byte, error) {
+	params := req.URL.Query()
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		reader := req.Body
+		if req.GetBody != nil {
+			var err error
+			if reader, err = req.GetBody(); err != nil {
+				return cassetteRequest{}, nil, err
+			}
+		}
+		var err error
+		body, err = io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return cassetteRequest{}, nil, err
+		}
+		if req.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+			form, err := url.ParseQuery(string(body))
+			if err != nil {
+				return cassetteRequest{}, nil, err
+			}
+			for key, vals := range form {
+				params[key] = append(params[key], vals...)
+			}
+		} else if len(body) > 0 {
+			params.Set("__body", string(body))
+		}
+	}
+	for _, name := range cassetteTimeParams {
+		for i, val := range params[name] {
+			params[name][i] = normalizeTime(val)
+		}
+	}
+	base := *req.URL
+	base.RawQuery = ""
+	base.Fragment = ""
+	return cassetteRequest{Method: req.Method, URL: base.String(), Params: params}, body, nil
+}
+
+// normalizeTime formats unix timestamps and RFC 3339 times the same way, other values are kept.
+func normalizeTime(val string) string {
+	var t time.Time
+	if seconds, err := strconv.ParseFloat(val, 64); err == nil {
+		t = time.UnixMilli(int64(math.Round(seconds * 1000)))
+	} else if parsed, err := time.Parse(time.RFC3339Nano, val); err == nil {
+		t = parsed.Round(time.Millisecond)
+	} else {
+		return val
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// key is stable for equal requests since url.Values.Encode sorts by key.
+func (cr *cassetteRequest) key() string {
+	return sha256Hex([]byte(cr.Method + " " + cr.URL + "?" + cr.Params.Encode()))[:32]
+}
+
+func (cr *cassetteRequest) String() string {
+	if len(cr.Params) == 0 {
+		return cr.Method + " " + cr.URL
+	}
+	return cr.Method + " " + cr.URL + "?" + cr.Params.Encode()
+}
+
+// RecordingRoundTripper saves every response to a cassette directory as one
+// JSON file per normalized request.
+type RecordingRoundTripper struct {
+	Dir  string
+	Next http.RoundTripper
+}
+
+func (rrt *RecordingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	normalized, body, err := normalizeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body.Close()
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	res, err := rrt.Next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err = io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	header := res.Header.Clone()
+	// the go transport already decoded the body
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	header.Del("Set-Cookie")
+	entry := cassetteEntry{
+		Request:  normalized,
+		Response: cassetteResponse{Status: res.StatusCode, Header: header},
+	}
+	if utf8.Valid(body) {
+		entry.Response.Body = string(body)
+	} else {
+		entry.Response.Body = base64.StdEncoding.EncodeToString(body)
+		entry.Response.BodyEncoding = "base64"
+	}
+	if err := writeCassetteEntry(rrt.Dir, &entry); err != nil {
+		return nil, fmt.Errorf("failed to record %s: %w", normalized.String(), err)
+	}
+	return res, nil
+}
+
+func writeCassetteEntry(dir string, entry *cassetteEntry) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	marshaled, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".record-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(marshaled); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, entry.Request.key()+".json"))
+}
+
+// ReplayRoundTripper serves responses from a cassette directory written by
+// RecordingRoundTripper and fails for every request that was not recorded.
+type ReplayRoundTripper struct {
+	Dir string
+}
+
+func (rrt *ReplayRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	normalized, _, err := normalizeRequest(req)
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(filepath.Join(rrt.Dir, normalized.key()+".json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no recorded response in %s for %s, time windows have to match the recording exactly", rrt.Dir, normalized.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry cassetteEntry
+	if err := json.Unmarshal(content, &entry); err != nil {
+		return nil, fmt.Errorf("invalid cassette entry for %s: %w", normalized.String(), err)
+	}
+	body := []byte(entry.Response.Body)
+	if entry.Response.BodyEncoding == "base64" {
+		body, err = base64.StdEncoding.DecodeString(entry.Response.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cassette entry for %s: %w", normalized.String(), err)
+		}
+	}
+	if entry.Response.Header == nil {
+		entry.Response.Header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.Response.Status, http.StatusText(entry.Response.Status)),
+		StatusCode:    entry.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        entry.Response.Header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	promapi "github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// fakePrometheus answers range queries with a series carrying the request
+// parameters as labels and serves a binary body.
+func fakePrometheus(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/query_range":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"__name__":%q,"start":%q,"end":%q},"values":[[%s,"1"]]}]}}`,
+				r.Form.Get("query"), r.Form.Get("start"), r.Form.Get("end"), r.Form.Get("start"))
+		case "/api/v1/labels":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"status":"success","data":[%q]}`, strings.Join(r.Form["match[]"], ","))
+		case "/binary":
+			w.Write([]byte{0xff, 0x00, 0xfe})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newCassetteAPI(t *testing.T, url string, cfg HTTPConfig) v1.API {
+	httpClient, err := MakeHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiClient, err := promapi.NewClient(promapi.Config{Address: url, Client: &httpClient})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v1.NewAPI(apiClient)
+}
+
+func TestCassetteRecordReplay(t *testing.T) {
+	server := fakePrometheus(t)
+	dir := t.TempDir()
+	ctx := context.Background()
+	window := v1.Range{Start: time.Unix(1696154400, 0), End: time.Unix(1696161600, 0), Step: time.Minute}
+	matches := []string{`up{job="node"}`, "node_load1"}
+
+	recording := newCassetteAPI(t, server.URL, HTTPConfig{Record: true, Cassette: dir})
+	recorded, _, err := recording.QueryRange(ctx, "up", window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordedLabels, _, err := recording.LabelNames(ctx, matches, window.Start, window.End)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpClient, err := MakeHTTPClient(HTTPConfig{Record: true, Cassette: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := httpClient.Get(server.URL + "/binary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	server.Close()
+
+	replaying := newCassetteAPI(t, server.URL, HTTPConfig{Backend: BackendReplay, Cassette: dir})
+	replayed, _, err := replaying.QueryRange(ctx, "up", window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(recorded, replayed) {
+		t.Errorf("replayed %v, recorded %v", replayed, recorded)
+	}
+	replayedLabels, _, err := replaying.LabelNames(ctx, matches, window.Start, window.End)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(recordedLabels, replayedLabels) || len(replayedLabels) != 1 || replayedLabels[0] != strings.Join(matches, ",") {
+		t.Errorf("replayed labels %v, recorded %v", replayedLabels, recordedLabels)
+	}
+
+	// the order of parameters and the format of timestamps do not matter
+	replayClient, err := MakeHTTPClient(HTTPConfig{Backend: BackendReplay, Cassette: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := fmt.Sprintf("step=60&end=%s&start=%s&query=up",
+		url.QueryEscape(window.End.UTC().Format(time.RFC3339)), url.QueryEscape(window.Start.In(time.FixedZone("CEST", 7200)).Format(time.RFC3339Nano)))
+	res, err = replayClient.Post(server.URL+"/api/v1/query_range", "application/x-www-form-urlencoded", strings.NewReader(form))
+	if err != nil {
+		t.Fatalf("reordered request was not replayed: %s", err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), `"start":"1696154400"`) {
+		t.Errorf("unexpected replayed body %s", body)
+	}
+
+	res, err = replayClient.Get(server.URL + "/binary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	if !bytes.Equal(body, []byte{0xff, 0x00, 0xfe}) {
+		t.Errorf("binary body was replayed as %v", body)
+	}
+
+	// requests for another window fail loudly instead of reaching the network
+	_, _, err = replaying.QueryRange(ctx, "up", v1.Range{Start: window.Start.Add(time.Second), End: window.End, Step: time.Minute})
+	if err == nil || !strings.Contains(err.Error(), "no recorded response") {
+		t.Errorf("expected an unknown request to fail, got %v", err)
+	}
+}
+
+func TestRecordingKeepsRequestBody(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	rrt := &RecordingRoundTripper{Dir: dir, Next: http.DefaultTransport}
+	for _, getBody := range []bool{true, false} {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/query", strings.NewReader("query=up&time=1.5"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if !getBody {
+			req.GetBody = nil
+		}
+		res, err := rrt.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if received != "query=up&time=1.5" {
+			t.Errorf("server received %q with GetBody %v", received, getBody)
+		}
+		normalized, _, err := normalizeRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if getBody && normalized.Params.Get("time") != "1970-01-01T00:00:01.5Z" {
+			t.Errorf("unexpected normalized request %s", normalized.String())
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected both requests to share a cassette entry, got %d", len(entries))
+	}
+}
@@ -16,8 +16,12 @@ package client
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"net/http"
+	"net/url"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/ilmari-lauhakangas/go-curl"
 )
@@ -27,21 +31,118 @@ type HTTPBackend string
 const (
 	BackendGo   HTTPBackend = "go"
 	BackendCurl HTTPBackend = "curl"
+	// BackendReplay serves responses recorded to a cassette without any network access.
+	BackendReplay HTTPBackend = "replay"
 )
 
-func MakeHTTPClient(backend HTTPBackend, clientCert string) http.Client {
+type AuthMode string
+
+const (
+	AuthNone     AuthMode = ""
+	AuthKeystone AuthMode = "keystone"
+	AuthSigV4    AuthMode = "sigv4"
+)
+
+type HTTPConfig struct {
+	Backend    HTTPBackend
+	ClientCert string
+	Auth       AuthMode
+	// OSCloud selects an entry of clouds.yaml for keystone authentication.
+	// If empty the OS_* environment variables are used.
+	OSCloud string
+	// SigV4Region and SigV4Service scope the signature of sigv4 authentication.
+	// The region defaults to the one from the AWS environment or profile.
+	SigV4Region  string
+	SigV4Service string
+	// Proxies are given as PROXY or TARGET=PROXY, see ParseProxies.
+	Proxies []string
+	// Debug logs every request with its timings to stderr in the given format.
+	Debug DebugFormat
+	// Cassette is the directory responses are recorded to or replayed from.
+	Cassette string
+	Record   bool
+}
+
+func MakeHTTPClient(cfg HTTPConfig) (http.Client, error) {
 	client := http.Client{}
-	if backend == BackendCurl {
-		crt := CurlRoundTripper{ClientCertName: clientCert}
-		client.Transport = &crt
-		return client
+	proxies, err := ParseProxies(cfg.Proxies)
+	if err != nil {
+		return client, err
+	}
+	if (cfg.Record || cfg.Backend == BackendReplay) && cfg.Cassette == "" {
+		return client, fmt.Errorf("no cassette directory given")
+	}
+	var transport http.RoundTripper
+	switch cfg.Backend {
+	case BackendCurl:
+		transport = &CurlRoundTripper{ClientCertName: cfg.ClientCert, Proxies: proxies}
+	case BackendReplay:
+		if cfg.Record {
+			return client, fmt.Errorf("cannot record while replaying")
+		}
+		transport = &ReplayRoundTripper{Dir: cfg.Cassette}
+	default:
+		// net/http only supports socks5h from go 1.22 on
+		if proxies.usesScheme("socks5h") {
+			return client, fmt.Errorf("socks5h proxies are only supported by the curl backend, the go backend resolves host names on socks5 proxies as well")
+		}
+		goTransport := http.DefaultTransport.(*http.Transport).Clone()
+		goTransport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxies.ProxyFor(req.URL)
+		}
+		transport = goTransport
+	}
+	switch cfg.Debug {
+	case DebugOff:
+	case DebugText, DebugJSON:
+		transport = &TracingRoundTripper{Format: cfg.Debug, Writer: os.Stderr, Next: transport}
+	default:
+		return client, fmt.Errorf("unknown debug format: %s", cfg.Debug)
+	}
+	auth := cfg.Auth
+	// replayed responses were recorded after authentication already
+	if cfg.Backend == BackendReplay {
+		auth = AuthNone
+	}
+	switch auth {
+	case AuthNone:
+	case AuthKeystone:
+		auth, err := LoadKeystoneAuth(cfg.OSCloud)
+		if err != nil {
+			return client, err
+		}
+		transport = &KeystoneRoundTripper{Auth: auth, Next: transport}
+	case AuthSigV4:
+		creds, err := LoadAWSCredentials()
+		if err != nil {
+			return client, err
+		}
+		region := cfg.SigV4Region
+		if region == "" {
+			region = creds.Region
+		}
+		if region == "" {
+			return client, fmt.Errorf("no aws region given for sigv4")
+		}
+		service := cfg.SigV4Service
+		if service == "" {
+			service = DefaultSigV4Service
+		}
+		transport = &SigV4RoundTripper{Credentials: creds, Region: region, Service: service, Next: transport, Reload: LoadAWSCredentials}
+	default:
+		return client, fmt.Errorf("unknown auth mode: %s", cfg.Auth)
+	}
+	// recording happens outside of authentication, so credentials never end up in the cassette
+	if cfg.Record {
+		transport = &RecordingRoundTripper{Dir: cfg.Cassette, Next: transport}
 	}
-	// golang backend
-	return client
+	client.Transport = transport
+	return client, nil
 }
 
 type CurlRoundTripper struct {
 	ClientCertName string
+	Proxies        *ProxySelector
 }
 
 func (crt *CurlRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
@@ -67,6 +168,18 @@ func (crt *CurlRoundTripper) RoundTrip(req *http.Request) (*http.Response, error
 			return nil, err
 		}
 	}
+	headers := make([]string, 0, len(req.Header))
+	for key, values := range req.Header {
+		for _, value := range values {
+			headers = append(headers, fmt.Sprintf("%s: %s", key, value))
+		}
+	}
+	if len(headers) > 0 {
+		err = easy.Setopt(curl.OPT_HTTPHEADER, headers)
+		if err != nil {
+			return nil, err
+		}
+	}
 	err = easy.Setopt(curl.OPT_READFUNCTION,
 		func(ptr []byte, userdata interface{}) int {
 			written, _ := req.Body.Read(ptr)
@@ -84,6 +197,21 @@ func (crt *CurlRoundTripper) RoundTrip(req *http.Request) (*http.Response, error
 	if err != nil {
 		return nil, err
 	}
+	if crt.Proxies != nil {
+		proxy, err := crt.Proxies.ProxyFor(req.URL)
+		if err != nil {
+			return nil, err
+		}
+		// an empty proxy keeps curl from falling back to the environment
+		proxyStr := ""
+		if proxy != nil {
+			proxyStr = proxy.String()
+		}
+		err = easy.Setopt(curl.OPT_PROXY, proxyStr)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if crt.ClientCertName != "" {
 		err = easy.Setopt(curl.OPT_SSLCERT, crt.ClientCertName)
 		if err != nil {
@@ -98,6 +226,9 @@ func (crt *CurlRoundTripper) RoundTrip(req *http.Request) (*http.Response, error
 	if err != nil {
 		return nil, err
 	}
+	if recorder := timingsFrom(req.Context()); recorder != nil {
+		recorder.update(func(tr *timingsRecorder) { fillCurlTimings(easy, &tr.timings) })
+	}
 	resStr := response.String()
 	if strings.HasPrefix(resStr, "HTTP/2") {
 		resStr = strings.Replace(resStr, "HTTP/2", "HTTP/2.0", 1)
@@ -108,3 +239,30 @@ func (crt *CurlRoundTripper) RoundTrip(req *http.Request) (*http.Response, error
 	}
 	return res, nil
 }
+
+// fillCurlTimings converts the cumulative timings of curl into phases.
+func fillCurlTimings(easy *curl.CURL, timings *Timings) {
+	seconds := func(info curl.CurlInfo) time.Duration {
+		val, err := easy.Getinfo(info)
+		if err != nil {
+			return 0
+		}
+		secs, ok := val.(float64)
+		if !ok {
+			return 0
+		}
+		return time.Duration(secs * float64(time.Second))
+	}
+	nameLookup := seconds(curl.INFO_NAMELOOKUP_TIME)
+	connect := seconds(curl.INFO_CONNECT_TIME)
+	appConnect := seconds(curl.INFO_APPCONNECT_TIME)
+	timings.DNS = nameLookup
+	if connect > nameLookup {
+		timings.Connect = connect - nameLookup
+	}
+	if appConnect > connect {
+		timings.TLS = appConnect - connect
+	}
+	timings.TTFB = seconds(curl.INFO_STARTTRANSFER_TIME)
+	timings.Total = seconds(curl.INFO_TOTAL_TIME)
+}
@@ -0,0 +1,329 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// tokens are refreshed when they expire within this margin, but at most
+// within half of their lifetime, so short lived tokens are still reused
+const keystoneRefreshMargin = 5 * time.Minute
+
+type KeystoneAuth struct {
+	AuthURL                     string `yaml:"auth_url"`
+	Token                       string `yaml:"token"`
+	UserID                      string `yaml:"user_id"`
+	Username                    string `yaml:"username"`
+	Password                    string `yaml:"password"`
+	UserDomainID                string `yaml:"user_domain_id"`
+	UserDomainName              string `yaml:"user_domain_name"`
+	ProjectID                   string `yaml:"project_id"`
+	ProjectName                 string `yaml:"project_name"`
+	ProjectDomainID             string `yaml:"project_domain_id"`
+	ProjectDomainName           string `yaml:"project_domain_name"`
+	DomainID                    string `yaml:"domain_id"`
+	DomainName                  string `yaml:"domain_name"`
+	ApplicationCredentialID     string `yaml:"application_credential_id"`
+	ApplicationCredentialName   string `yaml:"application_credential_name"`
+	ApplicationCredentialSecret string `yaml:"application_credential_secret"`
+}
+
+// LoadKeystoneAuth reads the named cloud from clouds.yaml. If cloud is empty
+// OS_CLOUD is consulted and if that is unset as well the OS_* environment
+// variables are used.
+func LoadKeystoneAuth(cloud string) (KeystoneAuth, error) {
+	if cloud == "" {
+		cloud = os.Getenv("OS_CLOUD")
+	}
+	var auth KeystoneAuth
+	var err error
+	if cloud == "" {
+		auth = KeystoneAuthFromEnv()
+	} else {
+		auth, err = KeystoneAuthFromCloudsYAML(cloud)
+		if err != nil {
+			return auth, err
+		}
+	}
+	if auth.AuthURL == "" {
+		return auth, fmt.Errorf("keystone auth url is not set")
+	}
+	return auth, nil
+}
+
+func KeystoneAuthFromEnv() KeystoneAuth {
+	auth := KeystoneAuth{
+		AuthURL:                     os.Getenv("OS_AUTH_URL"),
+		Token:                       os.Getenv("OS_TOKEN"),
+		UserID:                      os.Getenv("OS_USER_ID"),
+		Username:                    os.Getenv("OS_USERNAME"),
+		Password:                    os.Getenv("OS_PASSWORD"),
+		UserDomainID:                os.Getenv("OS_USER_DOMAIN_ID"),
+		UserDomainName:              os.Getenv("OS_USER_DOMAIN_NAME"),
+		ProjectID:                   os.Getenv("OS_PROJECT_ID"),
+		ProjectName:                 os.Getenv("OS_PROJECT_NAME"),
+		ProjectDomainID:             os.Getenv("OS_PROJECT_DOMAIN_ID"),
+		ProjectDomainName:           os.Getenv("OS_PROJECT_DOMAIN_NAME"),
+		DomainID:                    os.Getenv("OS_DOMAIN_ID"),
+		DomainName:                  os.Getenv("OS_DOMAIN_NAME"),
+		ApplicationCredentialID:     os.Getenv("OS_APPLICATION_CREDENTIAL_ID"),
+		ApplicationCredentialName:   os.Getenv("OS_APPLICATION_CREDENTIAL_NAME"),
+		ApplicationCredentialSecret: os.Getenv("OS_APPLICATION_CREDENTIAL_SECRET"),
+	}
+	// legacy names still used by older openrc files
+	if auth.ProjectID == "" {
+		auth.ProjectID = os.Getenv("OS_TENANT_ID")
+	}
+	if auth.ProjectName == "" {
+		auth.ProjectName = os.Getenv("OS_TENANT_NAME")
+	}
+	return auth
+}
+
+type cloudsYAML struct {
+	Clouds map[string]struct {
+		Auth KeystoneAuth `yaml:"auth"`
+	} `yaml:"clouds"`
+}
+
+// KeystoneAuthFromCloudsYAML looks up cloud in the first clouds.yaml found in
+// the locations documented by openstacksdk.
+func KeystoneAuthFromCloudsYAML(cloud string) (KeystoneAuth, error) {
+	candidates := []string{os.Getenv("OS_CLIENT_CONFIG_FILE"), "clouds.yaml"}
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".config", "openstack", "clouds.yaml"))
+	}
+	candidates = append(candidates, "/etc/openstack/clouds.yaml")
+	for _, path := range candidates {
+		if path == "" {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return KeystoneAuth{}, err
+		}
+		var parsed cloudsYAML
+		if err := yaml.Unmarshal(content, &parsed); err != nil {
+			return KeystoneAuth{}, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		entry, ok := parsed.Clouds[cloud]
+		if !ok {
+			return KeystoneAuth{}, fmt.Errorf("cloud %s not found in %s", cloud, path)
+		}
+		return entry.Auth, nil
+	}
+	return KeystoneAuth{}, fmt.Errorf("no clouds.yaml found for cloud %s", cloud)
+}
+
+func (auth *KeystoneAuth) tokenURL() string {
+	url := strings.TrimSuffix(auth.AuthURL, "/")
+	if !strings.HasSuffix(url, "/v3") {
+		url += "/v3"
+	}
+	return url + "/auth/tokens"
+}
+
+type keystoneDomain struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+func makeKeystoneDomain(id, name string) *keystoneDomain {
+	if id == "" && name == "" {
+		return nil
+	}
+	return &keystoneDomain{ID: id, Name: name}
+}
+
+func (auth *KeystoneAuth) user() map[string]interface{} {
+	if auth.UserID != "" {
+		return map[string]interface{}{"id": auth.UserID}
+	}
+	user := map[string]interface{}{"name": auth.Username}
+	if domain := makeKeystoneDomain(auth.UserDomainID, auth.UserDomainName); domain != nil {
+		user["domain"] = domain
+	}
+	return user
+}
+
+func (auth *KeystoneAuth) requestBody() ([]byte, error) {
+	identity := map[string]interface{}{}
+	switch {
+	case auth.ApplicationCredentialID != "" || auth.ApplicationCredentialName != "":
+		credential := map[string]interface{}{"secret": auth.ApplicationCredentialSecret}
+		if auth.ApplicationCredentialID != "" {
+			credential["id"] = auth.ApplicationCredentialID
+		} else {
+			credential["name"] = auth.ApplicationCredentialName
+			credential["user"] = auth.user()
+		}
+		identity["methods"] = []string{"application_credential"}
+		identity["application_credential"] = credential
+	case auth.Token != "":
+		identity["methods"] = []string{"token"}
+		identity["token"] = map[string]string{"id": auth.Token}
+	case auth.UserID != "" || auth.Username != "":
+		user := auth.user()
+		user["password"] = auth.Password
+		identity["methods"] = []string{"password"}
+		identity["password"] = map[string]interface{}{"user": user}
+	default:
+		return nil, fmt.Errorf("no keystone credentials given")
+	}
+	body := map[string]interface{}{"identity": identity}
+	// application credentials are always bound to a project
+	if auth.ApplicationCredentialID == "" && auth.ApplicationCredentialName == "" {
+		switch {
+		case auth.ProjectID != "":
+			body["scope"] = map[string]interface{}{"project": map[string]string{"id": auth.ProjectID}}
+		case auth.ProjectName != "":
+			domainID, domainName := auth.ProjectDomainID, auth.ProjectDomainName
+			if domainID == "" && domainName == "" {
+				domainID, domainName = auth.UserDomainID, auth.UserDomainName
+			}
+			project := map[string]interface{}{"name": auth.ProjectName}
+			if domain := makeKeystoneDomain(domainID, domainName); domain != nil {
+				project["domain"] = domain
+			}
+			body["scope"] = map[string]interface{}{"project": project}
+		case auth.DomainID != "" || auth.DomainName != "":
+			body["scope"] = map[string]interface{}{"domain": makeKeystoneDomain(auth.DomainID, auth.DomainName)}
+		}
+	}
+	return json.Marshal(map[string]interface{}{"auth": body})
+}
+
+// KeystoneRoundTripper sets a keystone token as X-Auth-Token on every request.
+// The token is issued lazily, cached and reissued shortly before it expires.
+type KeystoneRoundTripper struct {
+	Auth KeystoneAuth
+	Next http.RoundTripper
+
+	mutex     sync.Mutex
+	token     string
+	expiresAt time.Time
+	margin    time.Duration
+}
+
+func (krt *KeystoneRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := krt.currentToken(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	authed := req.Clone(req.Context())
+	authed.Header.Set("X-Auth-Token", token)
+	res, err := krt.Next.RoundTrip(authed)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+	// the token may have been revoked, retry once with a fresh one if the body can be replayed
+	if req.Body != nil && req.GetBody == nil {
+		return res, nil
+	}
+	res.Body.Close()
+	krt.invalidate(token)
+	token, err = krt.currentToken(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("X-Auth-Token", token)
+	return krt.Next.RoundTrip(retry)
+}
+
+func (krt *KeystoneRoundTripper) currentToken(ctx context.Context) (string, error) {
+	krt.mutex.Lock()
+	defer krt.mutex.Unlock()
+	if krt.token != "" && time.Until(krt.expiresAt) > krt.margin {
+		return krt.token, nil
+	}
+	token, expiresAt, err := krt.issueToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	krt.token = token
+	krt.expiresAt = expiresAt
+	krt.margin = keystoneRefreshMargin
+	if lifetime := time.Until(expiresAt); lifetime/2 < krt.margin {
+		krt.margin = lifetime / 2
+	}
+	return token, nil
+}
+
+func (krt *KeystoneRoundTripper) invalidate(token string) {
+	krt.mutex.Lock()
+	defer krt.mutex.Unlock()
+	if krt.token == token {
+		krt.token = ""
+	}
+}
+
+func (krt *KeystoneRoundTripper) issueToken(ctx context.Context) (string, time.Time, error) {
+	body, err := krt.Auth.requestBody()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, krt.Auth.tokenURL(), bytes.NewReader(body))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := krt.Next.RoundTrip(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("keystone token request failed: %w", err)
+	}
+	defer res.Body.Close()
+	content, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if res.StatusCode != http.StatusCreated {
+		return "", time.Time{}, fmt.Errorf("keystone token request failed with %s: %s", res.Status, strings.TrimSpace(string(content)))
+	}
+	token := res.Header.Get("X-Subject-Token")
+	if token == "" {
+		return "", time.Time{}, fmt.Errorf("keystone response is missing X-Subject-Token")
+	}
+	var parsed struct {
+		Token struct {
+			ExpiresAt time.Time `json:"expires_at"`
+		} `json:"token"`
+	}
+	if err := json.Unmarshal(content, &parsed); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to parse keystone response: %w", err)
+	}
+	return token, parsed.Token.ExpiresAt, nil
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKeystone issues numbered tokens valid for lifetime and serves
+// /api/v1/query for tokens which were not revoked.
+type fakeKeystone struct {
+	mutex    sync.Mutex
+	lifetime time.Duration
+	issued   int
+	revoked  map[string]bool
+	auths    []map[string]interface{}
+	bodies   []string
+}
+
+func newFakeKeystone(t *testing.T, lifetime time.Duration) (*fakeKeystone, *httptest.Server) {
+	keystone := &fakeKeystone{lifetime: lifetime, revoked: make(map[string]bool)}
+	server := httptest.NewServer(keystone)
+	t.Cleanup(server.Close)
+	return keystone, server
+}
+
+func (k *fakeKeystone) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	switch r.URL.Path {
+	case "/v3/auth/tokens":
+		var parsed map[string]interface{}
+		if err := json.Unmarshal(body, &parsed); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		k.auths = append(k.auths, parsed)
+		k.issued++
+		w.Header().Set("X-Subject-Token", fmt.Sprintf("token-%d", k.issued))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token": {"expires_at": %q}}`, time.Now().Add(k.lifetime).UTC().Format(time.RFC3339))
+	case "/api/v1/query":
+		token := r.Header.Get("X-Auth-Token")
+		if !strings.HasPrefix(token, "token-") || k.revoked[token] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		k.bodies = append(k.bodies, string(body))
+		fmt.Fprint(w, token)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// requests returns the number of issued tokens, the token requests and the api request bodies.
+func (k *fakeKeystone) requests() (int, []map[string]interface{}, []string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.issued, append([]map[string]interface{}(nil), k.auths...), append([]string(nil), k.bodies...)
+}
+
+func (k *fakeKeystone) revoke(token string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.revoked[token] = true
+}
+
+func get(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+	res, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %s", res.Status)
+	}
+	return string(body)
+}
+
+func clearKeystoneEnv(t *testing.T) {
+	for _, env := range os.Environ() {
+		if name, _, _ := strings.Cut(env, "="); strings.HasPrefix(name, "OS_") {
+			t.Setenv(name, "")
+		}
+	}
+}
+
+func TestKeystoneTokenFromEnv(t *testing.T) {
+	keystone, server := newFakeKeystone(t, time.Hour)
+	clearKeystoneEnv(t)
+	t.Setenv("OS_AUTH_URL", server.URL+"/v3/")
+	t.Setenv("OS_USERNAME", "user")
+	t.Setenv("OS_PASSWORD", "secret")
+	t.Setenv("OS_USER_DOMAIN_NAME", "Default")
+	t.Setenv("OS_PROJECT_NAME", "monitoring")
+	auth, err := LoadKeystoneAuth("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &KeystoneRoundTripper{Auth: auth, Next: http.DefaultTransport}}
+	for i := 0; i < 3; i++ {
+		if token := get(t, client, server.URL+"/api/v1/query"); token != "token-1" {
+			t.Errorf("request %d used %s, expected the cached token-1", i, token)
+		}
+	}
+	issued, auths, _ := keystone.requests()
+	if issued != 1 {
+		t.Fatalf("expected one token to be issued, got %d", issued)
+	}
+	encoded, _ := json.Marshal(auths[0]["auth"])
+	expected := `{"identity":{"methods":["password"],"password":{"user":{"domain":{"name":"Default"},"name":"user","password":"secret"}}},` +
+		`"scope":{"project":{"domain":{"name":"Default"},"name":"monitoring"}}}`
+	if string(encoded) != expected {
+		t.Errorf("unexpected auth request\n got: %s\nwant: %s", encoded, expected)
+	}
+}
+
+func TestKeystoneTokenFromCloudsYAML(t *testing.T) {
+	keystone, server := newFakeKeystone(t, time.Hour)
+	clearKeystoneEnv(t)
+	path := filepath.Join(t.TempDir(), "clouds.yaml")
+	content := fmt.Sprintf(`clouds:
+  other:
+    auth:
+      auth_url: http://invalid
+  prod:
+    auth:
+      auth_url: %s
+      application_credential_id: app-id
+      application_credential_secret: app-secret
+`, server.URL)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OS_CLIENT_CONFIG_FILE", path)
+	t.Setenv("OS_CLOUD", "prod")
+	auth, err := LoadKeystoneAuth("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &KeystoneRoundTripper{Auth: auth, Next: http.DefaultTransport}}
+	if token := get(t, client, server.URL+"/api/v1/query"); token != "token-1" {
+		t.Errorf("unexpected token %s", token)
+	}
+	_, auths, _ := keystone.requests()
+	encoded, _ := json.Marshal(auths[0])
+	expected := `{"auth":{"identity":{"application_credential":{"id":"app-id","secret":"app-secret"},"methods":["application_credential"]}}}`
+	if string(encoded) != expected {
+		t.Errorf("unexpected auth request\n got: %s\nwant: %s", encoded, expected)
+	}
+	if _, err := LoadKeystoneAuth("missing"); err == nil {
+		t.Error("expected an error for an unknown cloud")
+	}
+}
+
+func TestKeystoneRefreshMargin(t *testing.T) {
+	cases := []struct {
+		lifetime time.Duration
+		margin   time.Duration
+	}{
+		{time.Hour, keystoneRefreshMargin},
+		// short lived tokens would be within the margin right away
+		{4 * time.Minute, 2 * time.Minute},
+	}
+	for _, c := range cases {
+		_, server := newFakeKeystone(t, c.lifetime)
+		rt := &KeystoneRoundTripper{Auth: KeystoneAuth{AuthURL: server.URL, Token: "initial"}, Next: http.DefaultTransport}
+		client := &http.Client{Transport: rt}
+		for i := 0; i < 3; i++ {
+			if token := get(t, client, server.URL+"/api/v1/query"); token != "token-1" {
+				t.Errorf("%s: request %d used %s instead of the cached token-1", c.lifetime, i, token)
+			}
+		}
+		rt.mutex.Lock()
+		if rt.margin < c.margin-time.Second || rt.margin > c.margin {
+			t.Errorf("%s: refresh margin is %s, want %s", c.lifetime, rt.margin, c.margin)
+		}
+		// once the token moves into the margin it is refreshed before it expires
+		rt.expiresAt = time.Now().Add(rt.margin - time.Second)
+		rt.mutex.Unlock()
+		if token := get(t, client, server.URL+"/api/v1/query"); token != "token-2" {
+			t.Errorf("%s: expected a refreshed token-2, got %s", c.lifetime, token)
+		}
+	}
+}
+
+func TestKeystoneRetryOnUnauthorized(t *testing.T) {
+	keystone, server := newFakeKeystone(t, time.Hour)
+	client := &http.Client{Transport: &KeystoneRoundTripper{
+		Auth: KeystoneAuth{AuthURL: server.URL, Token: "initial"},
+		Next: http.DefaultTransport,
+	}}
+	if token := get(t, client, server.URL+"/api/v1/query"); token != "token-1" {
+		t.Fatalf("unexpected token %s", token)
+	}
+	keystone.revoke("token-1")
+	res, err := client.Post(server.URL+"/api/v1/query", "application/x-www-form-urlencoded", bytes.NewReader([]byte("query=up")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(token) != "token-2" {
+		t.Fatalf("expected a retry with token-2, got %s %s", res.Status, token)
+	}
+	if _, _, bodies := keystone.requests(); len(bodies) != 2 || bodies[1] != "query=up" {
+		t.Errorf("expected the body to be replayed on retry, got %q", bodies)
+	}
+
+	// the retry happens only once
+	keystone.revoke("token-2")
+	keystone.revoke("token-3")
+	res, err = client.Get(server.URL + "/api/v1/query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if issued, _, _ := keystone.requests(); res.StatusCode != http.StatusUnauthorized || issued != 3 {
+		t.Errorf("expected a single retry, got %s after %d tokens", res.Status, issued)
+	}
+
+	// bodies which cannot be replayed are not retried
+	keystone.revoke("token-3")
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/query", io.NopCloser(strings.NewReader("query=up")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if issued, _, _ := keystone.requests(); res.StatusCode != http.StatusUnauthorized || issued != 3 {
+		t.Errorf("expected no retry without GetBody, got %s after %d tokens", res.Status, issued)
+	}
+}
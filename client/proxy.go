@@ -0,0 +1,158 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxyDirect disables proxying for a single prometheus.
+const ProxyDirect = "direct"
+
+type proxyRoute struct {
+	target string
+	proxy  *url.URL
+}
+
+// ProxySelector picks the proxy for a request. Routes for single prometheis
+// take precedence over the default proxy, which honors NO_PROXY. Without a
+// default proxy HTTP_PROXY, HTTPS_PROXY and NO_PROXY are used.
+type ProxySelector struct {
+	routes   []proxyRoute
+	fallback func(*url.URL) (*url.URL, error)
+	// proxies are all configured proxies including the default one
+	proxies []*url.URL
+}
+
+var proxySchemes = []string{"http", "https", "socks5", "socks5h"}
+
+// ParseProxies parses specs of the form PROXY or TARGET=PROXY, where TARGET is
+// a prometheus url or host and PROXY is a http, https, socks5 or socks5h url or direct.
+func ParseProxies(specs []string) (*ProxySelector, error) {
+	selector := ProxySelector{}
+	defaultProxy := ""
+	for _, spec := range specs {
+		target, proxy, found := cutProxySpec(spec)
+		if !found {
+			if defaultProxy != "" {
+				return nil, fmt.Errorf("more than one default proxy given")
+			}
+			defaultProxy = spec
+			proxyURL, err := parseProxyURL(spec)
+			if err != nil {
+				return nil, err
+			}
+			selector.add(proxyURL)
+			continue
+		}
+		proxyURL, err := parseProxyURL(proxy)
+		if err != nil {
+			return nil, err
+		}
+		selector.add(proxyURL)
+		selector.routes = append(selector.routes, proxyRoute{target: strings.TrimSuffix(target, "/"), proxy: proxyURL})
+	}
+	if defaultProxy == "" {
+		selector.fallback = httpproxy.FromEnvironment().ProxyFunc()
+	} else if defaultProxy == ProxyDirect {
+		selector.fallback = func(*url.URL) (*url.URL, error) { return nil, nil }
+	} else {
+		cfg := httpproxy.Config{
+			HTTPProxy:  defaultProxy,
+			HTTPSProxy: defaultProxy,
+			NoProxy:    firstEnv("NO_PROXY", "no_proxy"),
+		}
+		selector.fallback = cfg.ProxyFunc()
+	}
+	return &selector, nil
+}
+
+// cutProxySpec splits TARGET=PROXY at the last = followed by direct or a proxy url,
+// since target urls may contain = in their query string.
+func cutProxySpec(spec string) (target, proxy string, found bool) {
+	separator := -1
+	if strings.HasSuffix(spec, "="+ProxyDirect) {
+		separator = len(spec) - len(ProxyDirect) - 1
+	}
+	for _, scheme := range proxySchemes {
+		if i := strings.LastIndex(spec, "="+scheme+"://"); i > separator {
+			separator = i
+		}
+	}
+	if separator < 0 {
+		return "", spec, false
+	}
+	return spec[:separator], spec[separator+1:], true
+}
+
+func (ps *ProxySelector) add(proxy *url.URL) {
+	if proxy != nil {
+		ps.proxies = append(ps.proxies, proxy)
+	}
+}
+
+// usesScheme reports whether any configured proxy has the given scheme.
+func (ps *ProxySelector) usesScheme(scheme string) bool {
+	for _, proxy := range ps.proxies {
+		if proxy.Scheme == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+func parseProxyURL(proxy string) (*url.URL, error) {
+	if proxy == ProxyDirect {
+		return nil, nil
+	}
+	parsed, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %s: %w", proxy, err)
+	}
+	for _, scheme := range proxySchemes {
+		if parsed.Scheme == scheme {
+			return parsed, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme: %s", proxy)
+}
+
+// ProxyFor returns the proxy for target or nil if the request should go out directly.
+func (ps *ProxySelector) ProxyFor(target *url.URL) (*url.URL, error) {
+	var best *proxyRoute
+	for i, route := range ps.routes {
+		if !route.matches(target) {
+			continue
+		}
+		if best == nil || len(route.target) > len(best.target) {
+			best = &ps.routes[i]
+		}
+	}
+	if best != nil {
+		return best.proxy, nil
+	}
+	return ps.fallback(target)
+}
+
+func (route *proxyRoute) matches(target *url.URL) bool {
+	if strings.Contains(route.target, "://") {
+		rest, found := strings.CutPrefix(target.String(), route.target)
+		return found && (rest == "" || rest[0] == '/' || rest[0] == '?')
+	}
+	return target.Host == route.target || target.Hostname() == route.target
+}
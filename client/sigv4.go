@@ -0,0 +1,318 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sigV4Algorithm      = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat     = "20060102T150405Z"
+	sigV4DateFormat     = "20060102"
+	DefaultSigV4Service = "aps"
+	// credentials are reloaded after this interval to pick up rotated session tokens
+	sigV4CredentialsTTL = 5 * time.Minute
+)
+
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Region          string
+}
+
+// LoadAWSCredentials reads credentials from the AWS_* environment variables
+// and falls back to the profile named by AWS_PROFILE (or default) in the
+// shared credentials and config files.
+func LoadAWSCredentials() (AWSCredentials, error) {
+	creds := AWSCredentials{
+		AccessKeyID:     firstEnv("AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY"),
+		SecretAccessKey: firstEnv("AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Region:          firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+	}
+	profile := firstEnv("AWS_PROFILE", "AWS_DEFAULT_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	home, _ := os.UserHomeDir()
+	credentialsFile := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if credentialsFile == "" {
+		credentialsFile = filepath.Join(home, ".aws", "credentials")
+	}
+	configFile := os.Getenv("AWS_CONFIG_FILE")
+	if configFile == "" {
+		configFile = filepath.Join(home, ".aws", "config")
+	}
+	configSection := "profile " + profile
+	if profile == "default" {
+		configSection = profile
+	}
+	for _, source := range []struct{ path, section string }{{credentialsFile, profile}, {configFile, configSection}} {
+		values, err := readINISection(source.path, source.section)
+		if err != nil {
+			return creds, err
+		}
+		if creds.AccessKeyID == "" && values["aws_access_key_id"] != "" {
+			creds.AccessKeyID = values["aws_access_key_id"]
+			creds.SecretAccessKey = values["aws_secret_access_key"]
+			creds.SessionToken = values["aws_session_token"]
+		}
+		if creds.Region == "" {
+			creds.Region = values["region"]
+		}
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return creds, fmt.Errorf("no aws credentials found in environment or profile %s", profile)
+	}
+	return creds, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if val := os.Getenv(name); val != "" {
+			return val
+		}
+	}
+	return ""
+}
+
+// readINISection returns the key value pairs of section in the ini file at path.
+// A missing file is not an error.
+func readINISection(path, section string) (map[string]string, error) {
+	values := make(map[string]string)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return values, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	current := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, val, found := strings.Cut(line, "=")
+		if found && current == section {
+			values[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		}
+	}
+	return values, scanner.Err()
+}
+
+// SigV4RoundTripper signs every request with AWS signature version 4.
+type SigV4RoundTripper struct {
+	Credentials AWSCredentials
+	Region      string
+	Service     string
+	Next        http.RoundTripper
+	// Reload returns fresh credentials, it is called after sigV4CredentialsTTL and
+	// once a request is rejected, so session tokens rotated in the profile files are
+	// picked up. The credentials are static if it is nil.
+	Reload func() (AWSCredentials, error)
+
+	mutex    sync.Mutex
+	loadedAt time.Time
+}
+
+func (srt *SigV4RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	payload := []byte{}
+	if req.Body != nil {
+		var err error
+		payload, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	creds, err := srt.currentCredentials(false)
+	if err != nil {
+		return nil, err
+	}
+	res, err := srt.Next.RoundTrip(srt.signed(req, payload, creds))
+	if err != nil || srt.Reload == nil || (res.StatusCode != http.StatusUnauthorized && res.StatusCode != http.StatusForbidden) {
+		return res, err
+	}
+	// the session token may have expired, retry once if the profile has a new one
+	fresh, err := srt.currentCredentials(true)
+	if err != nil || fresh == creds {
+		return res, nil
+	}
+	res.Body.Close()
+	return srt.Next.RoundTrip(srt.signed(req, payload, fresh))
+}
+
+func (srt *SigV4RoundTripper) currentCredentials(forceReload bool) (AWSCredentials, error) {
+	srt.mutex.Lock()
+	defer srt.mutex.Unlock()
+	if srt.loadedAt.IsZero() {
+		srt.loadedAt = time.Now()
+	}
+	if srt.Reload == nil || (!forceReload && time.Since(srt.loadedAt) < sigV4CredentialsTTL) {
+		return srt.Credentials, nil
+	}
+	creds, err := srt.Reload()
+	if err != nil {
+		return srt.Credentials, fmt.Errorf("failed to reload aws credentials: %w", err)
+	}
+	srt.Credentials = creds
+	srt.loadedAt = time.Now()
+	return creds, nil
+}
+
+func (srt *SigV4RoundTripper) signed(req *http.Request, payload []byte, creds AWSCredentials) *http.Request {
+	signed := req.Clone(req.Context())
+	if req.Body != nil {
+		signed.Body = io.NopCloser(bytes.NewReader(payload))
+		signed.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(payload)), nil
+		}
+	}
+	srt.sign(signed, payload, creds, time.Now().UTC())
+	return signed
+}
+
+func (srt *SigV4RoundTripper) sign(req *http.Request, payload []byte, creds AWSCredentials, now time.Time) {
+	amzDate := now.Format(sigV4TimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	payloadHash := sha256Hex(payload)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for key, values := range req.Header {
+		lower := strings.ToLower(key)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.Join(values, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := strings.Builder{}
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.Join(strings.Fields(headers[name]), " ") + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		sigV4CanonicalPath(req.URL),
+		sigV4CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	date := now.Format(sigV4DateFormat)
+	scope := strings.Join([]string{date, srt.Region, srt.Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, srt.Region)
+	key = hmacSHA256(key, srt.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// sigV4CanonicalPath encodes each path segment a second time as required for all services but S3.
+func sigV4CanonicalPath(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = sigV4Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func sigV4CanonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	escaped := make(map[string][]string, len(values))
+	for key, vals := range values {
+		escapedKey := sigV4Escape(key)
+		keys = append(keys, escapedKey)
+		for _, val := range vals {
+			escaped[escapedKey] = append(escaped[escapedKey], sigV4Escape(val))
+		}
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(values))
+	for _, key := range keys {
+		vals := escaped[key]
+		sort.Strings(vals)
+		for _, val := range vals {
+			pairs = append(pairs, key+"="+val)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// sigV4Escape percent-encodes everything except the RFC 3986 unreserved characters.
+func sigV4Escape(s string) string {
+	escaped := strings.Builder{}
+	for _, b := range []byte(s) {
+		if ('A' <= b && b <= 'Z') || ('a' <= b && b <= 'z') || ('0' <= b && b <= '9') || b == '-' || b == '_' || b == '.' || b == '~' {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,434 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// exampleCredentials are the credentials of the AWS signature version 4 test suite.
+var exampleCredentials = AWSCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+
+func TestSigV4Escape(t *testing.T) {
+	cases := map[string]string{
+		"-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz": "-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+		"ሴ":                       "%E1%88%B4",
+		"value 1":                 "value%201",
+		"a+b=c/d":                 "a%2Bb%3Dc%2Fd",
+		`up{job="node"}[5m]`:      "up%7Bjob%3D%22node%22%7D%5B5m%5D",
+		"*":                       "%2A",
+		"":                        "",
+		"ws-1234/api%2Fv1":        "ws-1234%2Fapi%252Fv1",
+		"sum(rate(x[5m])) by (a)": "sum%28rate%28x%5B5m%5D%29%29%20by%20%28a%29",
+	}
+	for input, expected := range cases {
+		if escaped := sigV4Escape(input); escaped != expected {
+			t.Errorf("sigV4Escape(%q) = %q, want %q", input, escaped, expected)
+		}
+	}
+}
+
+func TestSigV4CanonicalQuery(t *testing.T) {
+	// the first cases are the get-vanilla-query-* and get-vanilla-utf8-query cases of the test suite
+	cases := map[string]string{
+		"":                              "",
+		"Param2=value2&Param1=value1":   "Param1=value1&Param2=value2",
+		"Param1=value2&Param1=Value1":   "Param1=Value1&Param1=value2",
+		"%E1%88%B4=bar":                 "%E1%88%B4=bar",
+		"Param1":                        "Param1=",
+		"Param1=value%201&b=%2A":        "Param1=value%201&b=%2A",
+		"query=up+%3D%3D+0&time=1.5":    "query=up%20%3D%3D%200&time=1.5",
+		"match%5B%5D=up&match%5B%5D=a":  "match%5B%5D=a&match%5B%5D=up",
+		"-._~09AZaz=-._~09AZaz&a=x%7Ey": "-._~09AZaz=-._~09AZaz&a=x~y",
+	}
+	for query, expected := range cases {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if canonical := sigV4CanonicalQuery(values); canonical != expected {
+			t.Errorf("sigV4CanonicalQuery(%q) = %q, want %q", query, canonical, expected)
+		}
+	}
+}
+
+func TestSigV4TestSuite(t *testing.T) {
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	cases := []struct {
+		url, contentType, service, expected string
+	}{
+		{
+			url:      "https://example.amazonaws.com/",
+			service:  "service",
+			expected: "Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			url:      "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			service:  "service",
+			expected: "Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			url:         "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+			contentType: "application/x-www-form-urlencoded; charset=utf-8",
+			service:     "iam",
+			expected:    "Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	}
+	for _, c := range cases {
+		req, err := http.NewRequest(http.MethodGet, c.url, http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.contentType != "" {
+			req.Header.Set("Content-Type", c.contentType)
+		}
+		srt := &SigV4RoundTripper{Region: "us-east-1", Service: c.service}
+		srt.sign(req, nil, exampleCredentials, now)
+		if auth := req.Header.Get("Authorization"); auth != sigV4Algorithm+" "+c.expected {
+			t.Errorf("unexpected signature for %s\n got: %s\nwant: %s %s", c.url, auth, sigV4Algorithm, c.expected)
+		}
+	}
+}
+
+// sigV4Verifier is a stand-in for an AMP endpoint, which derives the signature
+// of every request again and rejects it if it differs.
+type sigV4Verifier struct {
+	region, service string
+
+	mutex   sync.Mutex
+	secrets map[string]string
+	// tokens are the valid session tokens, requests without one are accepted as well
+	tokens   map[string]bool
+	accepted []string
+}
+
+func (v *sigV4Verifier) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := v.verify(r, body); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	v.accepted = append(v.accepted, r.Method+" "+r.URL.Path+" "+r.URL.RawQuery+string(body))
+	fmt.Fprint(w, "ok")
+}
+
+func (v *sigV4Verifier) verify(r *http.Request, body []byte) error {
+	var credential, signedHeaders, signature string
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return fmt.Errorf("missing authorization")
+	}
+	for _, field := range strings.Split(auth, ", ") {
+		key, val, _ := strings.Cut(field, "=")
+		switch key {
+		case "Credential":
+			credential = val
+		case "SignedHeaders":
+			signedHeaders = val
+		case "Signature":
+			signature = val
+		}
+	}
+	scope := strings.Split(credential, "/")
+	if len(scope) != 5 || scope[2] != v.region || scope[3] != v.service || scope[4] != "aws4_request" {
+		return fmt.Errorf("invalid credential scope %s", credential)
+	}
+	secret, ok := v.secrets[scope[0]]
+	if !ok {
+		return fmt.Errorf("unknown access key %s", scope[0])
+	}
+	if token := r.Header.Get("X-Amz-Security-Token"); token != "" && !v.tokens[token] {
+		return fmt.Errorf("the security token included in the request is expired")
+	}
+	amzDate, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil || time.Since(amzDate).Abs() > 5*time.Minute || amzDate.Format("20060102") != scope[1] {
+		return fmt.Errorf("invalid date %s", r.Header.Get("X-Amz-Date"))
+	}
+	headers := strings.Split(signedHeaders, ";")
+	required := []string{"host", "x-amz-date"}
+	if r.Header.Get("X-Amz-Security-Token") != "" {
+		required = append(required, "x-amz-security-token")
+	}
+	for _, name := range required {
+		if i := sort.SearchStrings(headers, name); !sort.StringsAreSorted(headers) || i == len(headers) || headers[i] != name {
+			return fmt.Errorf("%s is not signed in %s", name, signedHeaders)
+		}
+	}
+
+	// everything below is derived from the request as received, independently of the signer
+	escape := func(s string) string {
+		return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+	}
+	segments := strings.Split(r.URL.EscapedPath(), "/")
+	for i, segment := range segments {
+		segments[i] = escape(segment)
+	}
+	values, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		return err
+	}
+	pairs := [][2]string{}
+	for key, vals := range values {
+		for _, val := range vals {
+			pairs = append(pairs, [2]string{escape(key), escape(val)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	query := make([]string, len(pairs))
+	for i, pair := range pairs {
+		query[i] = pair[0] + "=" + pair[1]
+	}
+	canonicalHeaders := ""
+	for _, name := range headers {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders += name + ":" + strings.TrimSpace(value) + "\n"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{r.Method, strings.Join(segments, "/"), strings.Join(query, "&"),
+		canonicalHeaders, signedHeaders, hex.EncodeToString(payloadHash[:])}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + r.Header.Get("X-Amz-Date") + "\n" + strings.Join(scope[1:], "/") + "\n" + hex.EncodeToString(requestHash[:])
+	key := []byte("AWS4" + secret)
+	for _, part := range append(scope[1:], stringToSign) {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	if !hmac.Equal([]byte(hex.EncodeToString(key)), []byte(signature)) {
+		return fmt.Errorf("signature mismatch, canonical request:\n%s", canonicalRequest)
+	}
+	return nil
+}
+
+func newSigV4Verifier(t *testing.T) (*sigV4Verifier, *httptest.Server) {
+	verifier := &sigV4Verifier{
+		region:  "eu-west-1",
+		service: DefaultSigV4Service,
+		secrets: map[string]string{"AKIDEXAMPLE": exampleCredentials.SecretAccessKey},
+		tokens:  map[string]bool{"session-1": true},
+	}
+	server := httptest.NewServer(verifier)
+	t.Cleanup(server.Close)
+	return verifier, server
+}
+
+func TestSigV4AgainstVerifier(t *testing.T) {
+	verifier, server := newSigV4Verifier(t)
+	client := &http.Client{Transport: &SigV4RoundTripper{
+		Credentials: exampleCredentials,
+		Region:      "eu-west-1",
+		Service:     DefaultSigV4Service,
+		Next:        http.DefaultTransport,
+	}}
+	prefix := server.URL + "/workspaces/ws-1234/api/v1"
+	query := url.Values{"query": {`sum by (job) (rate(http_requests_total{code=~"5.."}[5m])) > 0`}, "time": {"1700000000.5"}}
+	requests := []func() (*http.Response, error){
+		func() (*http.Response, error) { return client.Get(prefix + "/query?" + query.Encode()) },
+		func() (*http.Response, error) {
+			return client.Get(prefix + "/series?match%5B%5D=up&match%5B%5D=a%20b&match-b=x&start=")
+		},
+		func() (*http.Response, error) { return client.PostForm(prefix+"/query_range", query) },
+		func() (*http.Response, error) { return client.Get(prefix + "/label/__name__/values") },
+		func() (*http.Response, error) { return client.Get(server.URL + "/a%20b/c~d") },
+	}
+	for i, request := range requests {
+		res, err := request()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("request %d was rejected: %s", i, body)
+		}
+	}
+	verifier.mutex.Lock()
+	if len(verifier.accepted) != len(requests) {
+		t.Errorf("expected %d accepted requests, got %q", len(requests), verifier.accepted)
+	}
+	verifier.mutex.Unlock()
+
+	// a wrong secret has to be detected by the verifier
+	client.Transport.(*SigV4RoundTripper).Credentials.SecretAccessKey = "wrong"
+	res, err := client.Get(prefix + "/query?" + query.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a wrong signature to be rejected, got %s", res.Status)
+	}
+}
+
+func TestSigV4ReloadsExpiredSessionToken(t *testing.T) {
+	verifier, server := newSigV4Verifier(t)
+	session := exampleCredentials
+	session.SessionToken = "session-1"
+	var reloads int
+	srt := &SigV4RoundTripper{
+		Credentials: session,
+		Region:      "eu-west-1",
+		Service:     DefaultSigV4Service,
+		Next:        http.DefaultTransport,
+		Reload: func() (AWSCredentials, error) {
+			reloads++
+			return session, nil
+		},
+	}
+	client := &http.Client{Transport: srt}
+	status := func() int {
+		t.Helper()
+		res, err := client.PostForm(server.URL+"/api/v1/query", url.Values{"query": {"up"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if code := status(); code != http.StatusOK || reloads != 0 {
+		t.Fatalf("unexpected status %d after %d reloads", code, reloads)
+	}
+
+	// the profile was updated with a new session token, which is picked up after the token is rejected
+	verifier.mutex.Lock()
+	verifier.tokens = map[string]bool{"session-2": true}
+	verifier.mutex.Unlock()
+	session.SessionToken = "session-2"
+	if code := status(); code != http.StatusOK || reloads != 1 {
+		t.Fatalf("expected a retry with the reloaded token, got %d after %d reloads", code, reloads)
+	}
+
+	// without a new token in the profile the rejection is returned
+	verifier.mutex.Lock()
+	verifier.tokens = map[string]bool{}
+	verifier.mutex.Unlock()
+	if code := status(); code != http.StatusForbidden || reloads != 2 {
+		t.Fatalf("expected the rejection to be returned, got %d after %d reloads", code, reloads)
+	}
+
+	// credentials are reloaded after the ttl even if nothing was rejected
+	verifier.mutex.Lock()
+	verifier.tokens = map[string]bool{"session-3": true}
+	verifier.mutex.Unlock()
+	session.SessionToken = "session-3"
+	srt.mutex.Lock()
+	srt.loadedAt = time.Now().Add(-sigV4CredentialsTTL)
+	srt.mutex.Unlock()
+	if code := status(); code != http.StatusOK || reloads != 3 {
+		t.Fatalf("expected the credentials to be reloaded after the ttl, got %d after %d reloads", code, reloads)
+	}
+}
+
+func TestLoadAWSCredentials(t *testing.T) {
+	dir := t.TempDir()
+	credentials := filepath.Join(dir, "credentials")
+	config := filepath.Join(dir, "config")
+	files := map[string]string{
+		credentials: `# managed by a credential helper
+[default]
+aws_access_key_id = default-key
+aws_secret_access_key=default-secret
+
+  [ monitoring ]
+; rotated every hour
+aws_access_key_id = monitoring-key
+aws_secret_access_key = monitoring/secret=
+aws_session_token = token==
+`,
+		config: `[default]
+region = us-east-1
+
+[profile monitoring]
+region = eu-central-1
+output = json
+[monitoring]
+region = wrong-section
+`,
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY",
+		"AWS_SESSION_TOKEN", "AWS_REGION", "AWS_DEFAULT_REGION", "AWS_PROFILE", "AWS_DEFAULT_PROFILE"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentials)
+	t.Setenv("AWS_CONFIG_FILE", config)
+
+	creds, err := LoadAWSCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (AWSCredentials{AccessKeyID: "default-key", SecretAccessKey: "default-secret", Region: "us-east-1"}); creds != expected {
+		t.Errorf("unexpected default credentials %+v", creds)
+	}
+
+	t.Setenv("AWS_PROFILE", "monitoring")
+	creds, err = LoadAWSCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := AWSCredentials{AccessKeyID: "monitoring-key", SecretAccessKey: "monitoring/secret=", SessionToken: "token==", Region: "eu-central-1"}
+	if creds != expected {
+		t.Errorf("unexpected profile credentials %+v", creds)
+	}
+
+	// the environment takes precedence over the profile
+	t.Setenv("AWS_ACCESS_KEY_ID", "env-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+	t.Setenv("AWS_REGION", "ap-south-1")
+	creds, err = LoadAWSCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (AWSCredentials{AccessKeyID: "env-key", SecretAccessKey: "env-secret", Region: "ap-south-1"}); creds != expected {
+		t.Errorf("unexpected environment credentials %+v", creds)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_PROFILE", "missing")
+	if _, err := LoadAWSCredentials(); err == nil {
+		t.Error("expected an error for a profile without credentials")
+	}
+}
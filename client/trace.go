@@ -0,0 +1,251 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type DebugFormat string
+
+const (
+	DebugOff  DebugFormat = ""
+	DebugText DebugFormat = "text"
+	DebugJSON DebugFormat = "json"
+)
+
+// headers whose values never end up in debug logs
+var redactedHeaders = map[string]struct{}{
+	"Authorization":        {},
+	"Proxy-Authorization":  {},
+	"Cookie":               {},
+	"X-Auth-Token":         {},
+	"X-Amz-Security-Token": {},
+}
+
+// Timings holds the phases of a single request. DNS, Connect and TLS are the
+// durations of the respective phase, TTFB and Total are measured from the
+// start of the request. Phases skipped due to connection reuse are zero.
+type Timings struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	TTFB    time.Duration
+	Total   time.Duration
+}
+
+// timingsRecorder guards the timings of a request, since httptrace callbacks
+// run on transport goroutines while the response is read on another one.
+type timingsRecorder struct {
+	mutex   sync.Mutex
+	timings Timings
+	// phase starts of the go backend
+	dnsStart, connectStart, tlsStart time.Time
+}
+
+func (tr *timingsRecorder) update(fn func(tr *timingsRecorder)) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	fn(tr)
+}
+
+func (tr *timingsRecorder) snapshot() Timings {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	return tr.timings
+}
+
+type timingsKey struct{}
+
+// timingsFrom returns the recorder a backend should fill in the timings of the request with, if any.
+func timingsFrom(ctx context.Context) *timingsRecorder {
+	recorder, _ := ctx.Value(timingsKey{}).(*timingsRecorder)
+	return recorder
+}
+
+// TracingRoundTripper logs every request with its timings once the response body is closed.
+type TracingRoundTripper struct {
+	Format DebugFormat
+	Writer io.Writer
+	Next   http.RoundTripper
+
+	mutex sync.Mutex
+}
+
+type traceEntry struct {
+	Method    string              `json:"method"`
+	URL       string              `json:"url"`
+	Form      url.Values          `json:"form,omitempty"`
+	Headers   map[string][]string `json:"headers"`
+	Status    int                 `json:"status,omitempty"`
+	Size      int64               `json:"size"`
+	DNSMs     float64             `json:"dns_ms"`
+	ConnectMs float64             `json:"connect_ms"`
+	TLSMs     float64             `json:"tls_ms"`
+	TTFBMs    float64             `json:"ttfb_ms"`
+	TotalMs   float64             `json:"total_ms"`
+	Error     string              `json:"error,omitempty"`
+}
+
+func (trt *TracingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry := traceEntry{
+		Method:  req.Method,
+		URL:     req.URL.String(),
+		Headers: redactHeaders(req.Header),
+	}
+	// prometheus queries are usually posted as form, so log the parameters as well
+	if req.GetBody != nil && req.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+		if body, err := req.GetBody(); err == nil {
+			content, _ := io.ReadAll(body)
+			body.Close()
+			entry.Form, _ = url.ParseQuery(string(content))
+		}
+	}
+	recorder := &timingsRecorder{}
+	start := time.Now()
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			recorder.update(func(tr *timingsRecorder) { tr.dnsStart = time.Now() })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			recorder.update(func(tr *timingsRecorder) { tr.timings.DNS = time.Since(tr.dnsStart) })
+		},
+		ConnectStart: func(string, string) {
+			recorder.update(func(tr *timingsRecorder) {
+				if tr.connectStart.IsZero() {
+					tr.connectStart = time.Now()
+				}
+			})
+		},
+		ConnectDone: func(string, string, error) {
+			recorder.update(func(tr *timingsRecorder) { tr.timings.Connect = time.Since(tr.connectStart) })
+		},
+		TLSHandshakeStart: func() {
+			recorder.update(func(tr *timingsRecorder) { tr.tlsStart = time.Now() })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			recorder.update(func(tr *timingsRecorder) { tr.timings.TLS = time.Since(tr.tlsStart) })
+		},
+		GotFirstResponseByte: func() {
+			recorder.update(func(tr *timingsRecorder) { tr.timings.TTFB = time.Since(start) })
+		},
+	}
+	finish := func() Timings {
+		recorder.update(func(tr *timingsRecorder) {
+			if tr.timings.Total == 0 {
+				tr.timings.Total = time.Since(start)
+			}
+		})
+		return recorder.snapshot()
+	}
+	ctx := context.WithValue(httptrace.WithClientTrace(req.Context(), trace), timingsKey{}, recorder)
+	res, err := trt.Next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		entry.Error = err.Error()
+		trt.log(entry, finish())
+		return nil, err
+	}
+	entry.Status = res.StatusCode
+	res.Body = &tracedBody{ReadCloser: res.Body, done: func(size int64, err error) {
+		entry.Size = size
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		trt.log(entry, finish())
+	}}
+	return res, nil
+}
+
+func (trt *TracingRoundTripper) log(entry traceEntry, timings Timings) {
+	entry.DNSMs = milliseconds(timings.DNS)
+	entry.ConnectMs = milliseconds(timings.Connect)
+	entry.TLSMs = milliseconds(timings.TLS)
+	entry.TTFBMs = milliseconds(timings.TTFB)
+	entry.TotalMs = milliseconds(timings.Total)
+	trt.mutex.Lock()
+	defer trt.mutex.Unlock()
+	if trt.Format == DebugJSON {
+		// errors while debugging must not break the actual request
+		_ = json.NewEncoder(trt.Writer).Encode(entry)
+		return
+	}
+	target := entry.URL
+	if len(entry.Form) > 0 {
+		target += " form=" + entry.Form.Encode()
+	}
+	headers := make([]string, 0, len(entry.Headers))
+	for name, values := range entry.Headers {
+		headers = append(headers, name+"="+strings.Join(values, ","))
+	}
+	sort.Strings(headers)
+	fmt.Fprintf(trt.Writer, "http: %s %s status=%d size=%d dns=%s connect=%s tls=%s ttfb=%s total=%s headers=[%s]",
+		entry.Method, target, entry.Status, entry.Size, timings.DNS, timings.Connect, timings.TLS, timings.TTFB, timings.Total,
+		strings.Join(headers, " "))
+	if entry.Error != "" {
+		fmt.Fprintf(trt.Writer, " error=%q", entry.Error)
+	}
+	fmt.Fprintln(trt.Writer)
+}
+
+func redactHeaders(header http.Header) map[string][]string {
+	redacted := make(map[string][]string, len(header))
+	for name, values := range header {
+		if _, ok := redactedHeaders[http.CanonicalHeaderKey(name)]; ok {
+			redacted[name] = []string{"REDACTED"}
+		} else {
+			redacted[name] = values
+		}
+	}
+	return redacted
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// tracedBody counts the bytes read and reports them exactly once on EOF or close.
+type tracedBody struct {
+	io.ReadCloser
+	size int64
+	once sync.Once
+	done func(size int64, err error)
+}
+
+func (tb *tracedBody) Read(p []byte) (int, error) {
+	n, err := tb.ReadCloser.Read(p)
+	tb.size += int64(n)
+	if err == io.EOF {
+		tb.once.Do(func() { tb.done(tb.size, nil) })
+	} else if err != nil {
+		tb.once.Do(func() { tb.done(tb.size, err) })
+	}
+	return n, err
+}
+
+func (tb *tracedBody) Close() error {
+	err := tb.ReadCloser.Close()
+	tb.once.Do(func() { tb.done(tb.size, nil) })
+	return err
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTracingRoundTripper(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		fmt.Fprint(w, `{"status":"success"}`)
+	}))
+	defer server.Close()
+	log := &bytes.Buffer{}
+	// fresh connections for every request, so all phases are traced concurrently
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = true
+	client := &http.Client{Transport: &TracingRoundTripper{Format: DebugJSON, Writer: log, Next: transport}}
+
+	const requests = 8
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/query?x=1", strings.NewReader(url.Values{"query": {fmt.Sprint("up", i)}}.Encode()))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("X-Auth-Token", "secret")
+			res, err := client.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_, _ = io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}(i)
+	}
+	wg.Wait()
+
+	scanner := bufio.NewScanner(log)
+	entries := 0
+	for scanner.Scan() {
+		var entry traceEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid log line %s: %s", scanner.Text(), err)
+		}
+		entries++
+		if entry.Method != http.MethodPost || entry.URL != server.URL+"/api/v1/query?x=1" || !strings.HasPrefix(entry.Form.Get("query"), "up") {
+			t.Errorf("unexpected request in %s", scanner.Text())
+		}
+		if entry.Status != http.StatusOK || entry.Size != int64(len(`{"status":"success"}`)) || entry.Error != "" {
+			t.Errorf("unexpected response in %s", scanner.Text())
+		}
+		if got := entry.Headers["X-Auth-Token"]; len(got) != 1 || got[0] != "REDACTED" {
+			t.Errorf("token was not redacted in %s", scanner.Text())
+		}
+		if entry.ConnectMs <= 0 || entry.TLSMs <= 0 || entry.TTFBMs < 5 || entry.TotalMs < entry.TTFBMs {
+			t.Errorf("implausible timings in %s", scanner.Text())
+		}
+	}
+	if entries != requests {
+		t.Errorf("expected %d log entries, got %d", requests, entries)
+	}
+}
+
+func TestTracingRoundTripperText(t *testing.T) {
+	log := &bytes.Buffer{}
+	client := &http.Client{Transport: &TracingRoundTripper{Format: DebugText, Writer: log, Next: http.DefaultTransport}}
+	_, err := client.Get("http://127.0.0.1:1/api/v1/query?query=up")
+	if err == nil {
+		t.Fatal("expected the request to a closed port to fail")
+	}
+	line := log.String()
+	for _, expected := range []string{"http: GET http://127.0.0.1:1/api/v1/query?query=up status=0 size=0 ", "error=", "total="} {
+		if !strings.Contains(line, expected) {
+			t.Errorf("expected %q in %q", expected, line)
+		}
+	}
+}
+
+func TestTracingRoundTripperCanceledDial(t *testing.T) {
+	// the tls handshake never completes before the request is canceled, but the
+	// dial continues in the background and reports its timings afterwards
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+			conn.Close()
+		}
+	}()
+	log := &bytes.Buffer{}
+	client := &http.Client{Transport: &TracingRoundTripper{Format: DebugJSON, Writer: log, Next: http.DefaultTransport.(*http.Transport).Clone()}}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+listener.Addr().String()+"/api/v1/query", http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(req); err == nil {
+		t.Fatal("expected the request to time out")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if !strings.Contains(log.String(), `"error":`) {
+		t.Errorf("expected the error to be logged, got %s", log.String())
+	}
+}
@@ -14,47 +14,151 @@ limitations under the License.
 package compressor
 
 import (
-	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
 )
 
 type Compression string
 
 const (
-	CompressionNone Compression = "none"
-	CompressionGzip Compression = "gzip"
+	CompressionNone   Compression = "none"
+	CompressionGzip   Compression = "gzip"
+	CompressionZstd   Compression = "zstd"
+	CompressionLZ4    Compression = "lz4"
+	CompressionSnappy Compression = "snappy"
+	CompressionXZ     Compression = "xz"
+	CompressionBzip2  Compression = "bzip2"
 )
 
-var compressorMap map[Compression]Compressor = map[Compression]Compressor{CompressionNone: NoneCompressor, CompressionGzip: GzipCompressor}
+// DefaultLevel selects the default level of the respective compression.
+const DefaultLevel = -1
 
-func Compress(in []byte, compression Compression) ([]byte, error) {
-	compressor, ok := compressorMap[compression]
-	if !ok {
-		return nil, fmt.Errorf("unknown compression: %s", compression)
-	}
-	return compressor(in)
+var compressorMap map[Compression]Compressor = map[Compression]Compressor{
+	CompressionNone:   NoneCompressor,
+	CompressionGzip:   GzipCompressor,
+	CompressionZstd:   ZstdCompressor,
+	CompressionLZ4:    LZ4Compressor,
+	CompressionSnappy: SnappyCompressor,
+	CompressionXZ:     XZCompressor,
+	CompressionBzip2:  Bzip2Compressor,
 }
 
-type Compressor func([]byte) ([]byte, error)
+// Compressor returns a writer that compresses everything written to it into w.
+// Closing it flushes all pending data but does not close w.
+type Compressor func(w io.Writer, level int) (io.WriteCloser, error)
 
-func NoneCompressor(in []byte) ([]byte, error) {
-	return in, nil
+// ParseCompression parses specs like gzip or zstd:19.
+func ParseCompression(spec string) (Compression, int, error) {
+	name, levelStr, hasLevel := strings.Cut(spec, ":")
+	compression := Compression(name)
+	if _, ok := compressorMap[compression]; !ok {
+		return "", 0, fmt.Errorf("unknown compression: %s", name)
+	}
+	if !hasLevel {
+		return compression, DefaultLevel, nil
+	}
+	level, err := strconv.Atoi(levelStr)
+	if err != nil || level < 0 {
+		return "", 0, fmt.Errorf("invalid compression level: %s", levelStr)
+	}
+	return compression, level, nil
 }
 
-func GzipCompressor(in []byte) ([]byte, error) {
-	out := bytes.Buffer{}
-	writer := gzip.NewWriter(&out)
-	_, err := io.Copy(writer, bytes.NewBuffer(in))
+// NewWriter wraps w with the compression given as spec, see ParseCompression.
+func NewWriter(w io.Writer, spec string) (io.WriteCloser, error) {
+	compression, level, err := ParseCompression(spec)
 	if err != nil {
 		return nil, err
 	}
-	if err := writer.Flush(); err != nil {
-		return nil, err
+	return compressorMap[compression](w, level)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func NoneCompressor(w io.Writer, level int) (io.WriteCloser, error) {
+	if level != DefaultLevel {
+		return nil, fmt.Errorf("compression none does not support levels")
 	}
-	if err := writer.Close(); err != nil {
-		return nil, err
+	return nopWriteCloser{w}, nil
+}
+
+func GzipCompressor(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == DefaultLevel {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+func ZstdCompressor(w io.Writer, level int) (io.WriteCloser, error) {
+	encoderLevel := zstd.SpeedDefault
+	if level != DefaultLevel {
+		if level < 1 || level > 22 {
+			return nil, fmt.Errorf("zstd level must be between 1 and 22")
+		}
+		encoderLevel = zstd.EncoderLevelFromZstd(level)
+	}
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(encoderLevel))
+}
+
+var lz4Levels = []lz4.CompressionLevel{lz4.Fast, lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4, lz4.Level5, lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9}
+
+func LZ4Compressor(w io.Writer, level int) (io.WriteCloser, error) {
+	writer := lz4.NewWriter(w)
+	if level != DefaultLevel {
+		if level >= len(lz4Levels) {
+			return nil, fmt.Errorf("lz4 level must be between 0 and 9")
+		}
+		if err := writer.Apply(lz4.CompressionLevelOption(lz4Levels[level])); err != nil {
+			return nil, err
+		}
+	}
+	return writer, nil
+}
+
+// SnappyCompressor writes the snappy framing format.
+func SnappyCompressor(w io.Writer, level int) (io.WriteCloser, error) {
+	if level != DefaultLevel {
+		return nil, fmt.Errorf("compression snappy does not support levels")
+	}
+	return snappy.NewBufferedWriter(w), nil
+}
+
+// xz has no levels as such, so they are mapped to the dictionary sizes of the xz presets.
+var xzDictCaps = []int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+func XZCompressor(w io.Writer, level int) (io.WriteCloser, error) {
+	cfg := xz.WriterConfig{}
+	if level != DefaultLevel {
+		if level >= len(xzDictCaps) {
+			return nil, fmt.Errorf("xz level must be between 0 and 9")
+		}
+		cfg.DictCap = xzDictCaps[level]
+	}
+	return cfg.NewWriter(w)
+}
+
+func Bzip2Compressor(w io.Writer, level int) (io.WriteCloser, error) {
+	cfg := bzip2.WriterConfig{}
+	if level != DefaultLevel {
+		if level < 1 || level > 9 {
+			return nil, fmt.Errorf("bzip2 level must be between 1 and 9")
+		}
+		cfg.Level = level
 	}
-	return out.Bytes(), nil
+	return bzip2.NewWriter(w, &cfg)
 }
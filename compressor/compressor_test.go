@@ -0,0 +1,186 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compressor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// levels lists the valid levels of each compression besides the default.
+var levels = map[Compression][]int{
+	CompressionNone:   nil,
+	CompressionGzip:   {0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+	CompressionZstd:   {1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22},
+	CompressionLZ4:    {0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+	CompressionSnappy: nil,
+	CompressionXZ:     {0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+	CompressionBzip2:  {1, 2, 3, 4, 5, 6, 7, 8, 9},
+}
+
+func decompress(compression Compression, data []byte) ([]byte, error) {
+	var reader io.Reader = bytes.NewReader(data)
+	var err error
+	switch compression {
+	case CompressionGzip:
+		reader, err = gzip.NewReader(reader)
+	case CompressionZstd:
+		reader, err = zstd.NewReader(reader)
+	case CompressionLZ4:
+		reader = lz4.NewReader(reader)
+	case CompressionSnappy:
+		reader = snappy.NewReader(reader)
+	case CompressionXZ:
+		reader, err = xz.NewReader(reader)
+	case CompressionBzip2:
+		reader, err = bzip2.NewReader(reader, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+func TestRoundTrip(t *testing.T) {
+	input := []byte(strings.Repeat(`{"metric":"up","labels":{"job":"node"},"timestamp":1696154400000,"value":1}`, 100))
+	if len(levels) != len(compressorMap) {
+		t.Fatalf("levels cover %d compressions, want %d", len(levels), len(compressorMap))
+	}
+	for compression, valid := range levels {
+		specs := []string{string(compression)}
+		for _, level := range valid {
+			specs = append(specs, fmt.Sprintf("%s:%d", compression, level))
+		}
+		for _, spec := range specs {
+			buf := bytes.Buffer{}
+			writer, err := NewWriter(&buf, spec)
+			if err != nil {
+				t.Errorf("%s: %s", spec, err)
+				continue
+			}
+			// several writes to check the writers keep state between them
+			for _, chunk := range [][]byte{input[:10], input[10:1000], input[1000:]} {
+				if _, err := writer.Write(chunk); err != nil {
+					t.Fatalf("%s: %s", spec, err)
+				}
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("%s: %s", spec, err)
+			}
+			output, err := decompress(compression, buf.Bytes())
+			if err != nil {
+				t.Errorf("%s: %s", spec, err)
+				continue
+			}
+			if !bytes.Equal(output, input) {
+				t.Errorf("%s: round trip returned %d bytes, want %d", spec, len(output), len(input))
+			}
+		}
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	valid := []struct {
+		spec        string
+		compression Compression
+		level       int
+	}{
+		{"none", CompressionNone, DefaultLevel},
+		{"gzip", CompressionGzip, DefaultLevel},
+		{"zstd:19", CompressionZstd, 19},
+		{"xz:0", CompressionXZ, 0},
+	}
+	for _, c := range valid {
+		compression, level, err := ParseCompression(c.spec)
+		if err != nil {
+			t.Errorf("%s: %s", c.spec, err)
+			continue
+		}
+		if compression != c.compression || level != c.level {
+			t.Errorf("%s: parsed %s at level %d, want %s at level %d", c.spec, compression, level, c.compression, c.level)
+		}
+	}
+	for _, spec := range []string{"", "zip", "gz:6", "gzip:", "gzip:fast", "gzip:-1", "zstd:1.5"} {
+		if _, _, err := ParseCompression(spec); err == nil {
+			t.Errorf("%q: parsed without error", spec)
+		}
+	}
+}
+
+func TestInvalidLevels(t *testing.T) {
+	specs := []string{
+		"none:0", "gzip:10", "zstd:0", "zstd:23", "lz4:10", "snappy:1", "xz:10", "bzip2:0", "bzip2:10",
+	}
+	for _, spec := range specs {
+		if _, _, err := ParseCompression(spec); err != nil {
+			t.Errorf("%s: level is rejected when parsing already: %s", spec, err)
+			continue
+		}
+		if _, err := NewWriter(io.Discard, spec); err == nil {
+			t.Errorf("%s: created writer for invalid level", spec)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	input := []byte(strings.Repeat("metric,timestamp,value\nup,1696154400000,1\n", 100))
+	for compression := range compressorMap {
+		buf := bytes.Buffer{}
+		writer, err := NewWriter(&buf, string(compression))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write(input); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if detected := Detect(buf.Bytes()); detected != compression {
+			t.Errorf("%s: detected %s", compression, detected)
+		}
+		reader, detected, err := NewReader(bytes.NewReader(buf.Bytes()), "")
+		if err != nil {
+			t.Errorf("%s: %s", compression, err)
+			continue
+		}
+		output, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Errorf("%s: %s", compression, err)
+			continue
+		}
+		if detected != compression || !bytes.Equal(output, input) {
+			t.Errorf("%s: read %d bytes as %s, want %d bytes", compression, len(output), detected, len(input))
+		}
+	}
+	for _, data := range []string{"", "{", "BZ", "\x1f"} {
+		if detected := Detect([]byte(data)); detected != CompressionNone {
+			t.Errorf("%q: detected %s", data, detected)
+		}
+	}
+	if _, _, err := NewReader(bytes.NewReader(nil), "zip"); err == nil {
+		t.Error("expected an unknown compression to be rejected")
+	}
+}
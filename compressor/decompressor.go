@@ -0,0 +1,121 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compressor
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// Decompressor returns a reader that decompresses everything read from r.
+// Closing it does not close r.
+type Decompressor func(r io.Reader) (io.ReadCloser, error)
+
+var decompressorMap map[Compression]Decompressor = map[Compression]Decompressor{
+	CompressionNone:   NoneDecompressor,
+	CompressionGzip:   GzipDecompressor,
+	CompressionZstd:   ZstdDecompressor,
+	CompressionLZ4:    LZ4Decompressor,
+	CompressionSnappy: SnappyDecompressor,
+	CompressionXZ:     XZDecompressor,
+	CompressionBzip2:  Bzip2Decompressor,
+}
+
+var magics = []struct {
+	compression Compression
+	magic       []byte
+}{
+	{CompressionGzip, []byte{0x1f, 0x8b}},
+	{CompressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{CompressionLZ4, []byte{0x04, 0x22, 0x4d, 0x18}},
+	{CompressionSnappy, []byte("\xff\x06\x00\x00sNaPpY")},
+	{CompressionXZ, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{CompressionBzip2, []byte("BZh")},
+}
+
+// Detect returns the compression of data from its magic bytes.
+func Detect(data []byte) Compression {
+	for _, m := range magics {
+		if bytes.HasPrefix(data, m.magic) {
+			return m.compression
+		}
+	}
+	return CompressionNone
+}
+
+// NewReader decompresses r with the given compression, which is detected from
+// the first bytes of r if empty. It returns the compression that was used.
+func NewReader(r io.Reader, compression Compression) (io.ReadCloser, Compression, error) {
+	if compression == "" {
+		buffered := bufio.NewReader(r)
+		// errors surface again on the first read
+		peeked, _ := buffered.Peek(10)
+		compression = Detect(peeked)
+		r = buffered
+	}
+	decompressor, ok := decompressorMap[compression]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown compression: %s", compression)
+	}
+	reader, err := decompressor(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s: %w", compression, err)
+	}
+	return reader, compression, nil
+}
+
+func NoneDecompressor(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+func GzipDecompressor(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func ZstdDecompressor(r io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
+
+func LZ4Decompressor(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(lz4.NewReader(r)), nil
+}
+
+func SnappyDecompressor(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(snappy.NewReader(r)), nil
+}
+
+func XZDecompressor(r io.Reader) (io.ReadCloser, error) {
+	reader, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(reader), nil
+}
+
+func Bzip2Decompressor(r io.Reader) (io.ReadCloser, error) {
+	return bzip2.NewReader(r, nil)
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diff
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+)
+
+type Config struct {
+	// Tolerance is the absolute difference up to which values are equal.
+	Tolerance float64
+	// RelativeTolerance is the difference relative to the left value up to which values are equal.
+	RelativeTolerance float64
+}
+
+// Report lists the differences between the left and the right side. Series are matched by their full label set.
+type Report struct {
+	Left        string       `json:"left"`
+	Right       string       `json:"right"`
+	LeftSeries  int          `json:"leftSeries"`
+	RightSeries int          `json:"rightSeries"`
+	Matched     int          `json:"matched"`
+	Missing     []string     `json:"missing"`
+	Extra       []string     `json:"extra"`
+	Different   []SeriesDiff `json:"different"`
+}
+
+// SeriesDiff describes the differences of a series present on both sides.
+type SeriesDiff struct {
+	Series       string `json:"series"`
+	LeftSamples  int    `json:"leftSamples"`
+	RightSamples int    `json:"rightSamples"`
+	// MissingSamples counts timestamps only present on the left side, ExtraSamples the ones only present on the right side.
+	MissingSamples int `json:"missingSamples"`
+	ExtraSamples   int `json:"extraSamples"`
+	// ValueDiffs counts common timestamps with values beyond the tolerance.
+	ValueDiffs int    `json:"valueDiffs"`
+	MaxDelta   string `json:"maxDelta,omitempty"`
+	// FirstDelta is the oldest value beyond the tolerance.
+	FirstDelta *Delta `json:"firstDelta,omitempty"`
+}
+
+// Delta holds values as strings like the Prometheus API, since they can be NaN or infinite.
+type Delta struct {
+	Timestamp time.Time `json:"timestamp"`
+	Left      string    `json:"left"`
+	Right     string    `json:"right"`
+}
+
+// Equal reports whether no differences were found.
+func (r *Report) Equal() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.Different) == 0
+}
+
+// Compare matches the series of left and right by their label sets and compares their samples.
+func Compare(left, right prommodel.Matrix, cfg Config) Report {
+	report := Report{
+		Missing:   make([]string, 0),
+		Extra:     make([]string, 0),
+		Different: make([]SeriesDiff, 0),
+	}
+	leftSeries, rightSeries := index(left), index(right)
+	report.LeftSeries, report.RightSeries = len(leftSeries), len(rightSeries)
+	for key, leftSamples := range leftSeries {
+		rightSamples, ok := rightSeries[key]
+		if !ok {
+			report.Missing = append(report.Missing, key)
+			continue
+		}
+		report.Matched++
+		if seriesDiff, differs := compareSamples(leftSamples, rightSamples, cfg); differs {
+			seriesDiff.Series = key
+			report.Different = append(report.Different, seriesDiff)
+		}
+	}
+	for key := range rightSeries {
+		if _, ok := leftSeries[key]; !ok {
+			report.Extra = append(report.Extra, key)
+		}
+	}
+	sort.Strings(report.Missing)
+	sort.Strings(report.Extra)
+	sort.Slice(report.Different, func(i, j int) bool { return report.Different[i].Series < report.Different[j].Series })
+	return report
+}
+
+// index maps series to their samples by timestamp, series occurring multiple times are merged.
+func index(matrix prommodel.Matrix) map[string]map[prommodel.Time]prommodel.SampleValue {
+	indexed := make(map[string]map[prommodel.Time]prommodel.SampleValue)
+	for _, stream := range matrix {
+		key := stream.Metric.String()
+		if indexed[key] == nil {
+			indexed[key] = make(map[prommodel.Time]prommodel.SampleValue)
+		}
+		for _, pair := range stream.Values {
+			indexed[key][pair.Timestamp] = pair.Value
+		}
+	}
+	return indexed
+}
+
+func compareSamples(left, right map[prommodel.Time]prommodel.SampleValue, cfg Config) (SeriesDiff, bool) {
+	seriesDiff := SeriesDiff{LeftSamples: len(left), RightSamples: len(right)}
+	timestamps := make([]prommodel.Time, 0, len(left))
+	for timestamp := range left {
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	maxDelta := 0.0
+	for _, timestamp := range timestamps {
+		leftValue := float64(left[timestamp])
+		rightValue, ok := right[timestamp]
+		if !ok {
+			seriesDiff.MissingSamples++
+			continue
+		}
+		delta, equal := compareValues(leftValue, float64(rightValue), cfg)
+		if equal {
+			continue
+		}
+		seriesDiff.ValueDiffs++
+		if delta > maxDelta || math.IsNaN(delta) {
+			maxDelta = delta
+		}
+		if seriesDiff.FirstDelta == nil {
+			seriesDiff.FirstDelta = &Delta{
+				Timestamp: timestamp.Time().UTC(),
+				Left:      formatValue(leftValue),
+				Right:     formatValue(float64(rightValue)),
+			}
+		}
+	}
+	for timestamp := range right {
+		if _, ok := left[timestamp]; !ok {
+			seriesDiff.ExtraSamples++
+		}
+	}
+	if seriesDiff.ValueDiffs > 0 {
+		seriesDiff.MaxDelta = formatValue(maxDelta)
+	}
+	differs := seriesDiff.MissingSamples > 0 || seriesDiff.ExtraSamples > 0 || seriesDiff.ValueDiffs > 0
+	return seriesDiff, differs
+}
+
+// compareValues treats NaNs as equal to each other and infinities as equal to themselves.
+func compareValues(left, right float64, cfg Config) (float64, bool) {
+	if math.IsNaN(left) || math.IsNaN(right) {
+		return math.NaN(), math.IsNaN(left) && math.IsNaN(right)
+	}
+	if left == right {
+		return 0, true
+	}
+	delta := math.Abs(left - right)
+	if delta <= cfg.Tolerance || delta <= cfg.RelativeTolerance*math.Abs(left) {
+		return delta, true
+	}
+	return delta, false
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// WriteText writes the report in a human readable form.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "left:\t%s (%d series)\n", r.Left, r.LeftSeries)
+	fmt.Fprintf(tw, "right:\t%s (%d series)\n", r.Right, r.RightSeries)
+	fmt.Fprintf(tw, "matched:\t%d series\n", r.Matched)
+	if r.Equal() {
+		fmt.Fprintln(tw, "no differences")
+		return tw.Flush()
+	}
+	for _, series := range r.Missing {
+		fmt.Fprintf(tw, "- %s\n", series)
+	}
+	for _, series := range r.Extra {
+		fmt.Fprintf(tw, "+ %s\n", series)
+	}
+	if len(r.Different) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "SERIES\tSAMPLES\tMISSING\tEXTRA\tVALUES\tMAX DELTA\tFIRST DELTA")
+		for _, d := range r.Different {
+			first := ""
+			if d.FirstDelta != nil {
+				first = fmt.Sprintf("%s %s != %s", d.FirstDelta.Timestamp.Format(time.RFC3339), d.FirstDelta.Left, d.FirstDelta.Right)
+			}
+			fmt.Fprintf(tw, "%s\t%d/%d\t%d\t%d\t%d\t%s\t%s\n", d.Series, d.LeftSamples, d.RightSamples, d.MissingSamples, d.ExtraSamples, d.ValueDiffs, d.MaxDelta, first)
+		}
+	}
+	fmt.Fprintf(tw, "\n%d missing, %d extra and %d different series\n", len(r.Missing), len(r.Extra), len(r.Different))
+	return tw.Flush()
+}
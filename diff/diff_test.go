@@ -0,0 +1,116 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diff
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	prommodel "github.com/prometheus/common/model"
+)
+
+func stream(metric prommodel.Metric, values ...float64) *prommodel.SampleStream {
+	s := &prommodel.SampleStream{Metric: metric}
+	for i, value := range values {
+		s.Values = append(s.Values, prommodel.SamplePair{Timestamp: prommodel.Time(i * 60000), Value: prommodel.SampleValue(value)})
+	}
+	return s
+}
+
+func TestCompareMatchesSeries(t *testing.T) {
+	up := prommodel.Metric{prommodel.MetricNameLabel: "up", "job": "node"}
+	left := prommodel.Matrix{
+		stream(up, 1, 1),
+		stream(prommodel.Metric{prommodel.MetricNameLabel: "up", "job": "api"}, 1),
+		// series are matched by their full label set
+		stream(prommodel.Metric{prommodel.MetricNameLabel: "up", "job": "node", "instance": "a"}, 1),
+	}
+	right := prommodel.Matrix{
+		stream(prommodel.Metric{"job": "node", prommodel.MetricNameLabel: "up"}, 1, 1),
+		stream(prommodel.Metric{prommodel.MetricNameLabel: "up", "job": "db"}, 1),
+		stream(prommodel.Metric{prommodel.MetricNameLabel: "up", "job": "node", "instance": "b"}, 1),
+	}
+	report := Compare(left, right, Config{})
+	if report.LeftSeries != 3 || report.RightSeries != 3 || report.Matched != 1 {
+		t.Errorf("unexpected counts %+v", report)
+	}
+	if expected := []string{`up{instance="a", job="node"}`, `up{job="api"}`}; !reflect.DeepEqual(report.Missing, expected) {
+		t.Errorf("missing %v, want %v", report.Missing, expected)
+	}
+	if expected := []string{`up{instance="b", job="node"}`, `up{job="db"}`}; !reflect.DeepEqual(report.Extra, expected) {
+		t.Errorf("extra %v, want %v", report.Extra, expected)
+	}
+	if len(report.Different) != 0 || report.Equal() {
+		t.Errorf("unexpected differences %+v", report.Different)
+	}
+
+	// series occurring multiple times are merged before comparing
+	split := prommodel.Matrix{stream(up, 1), {Metric: up, Values: []prommodel.SamplePair{{Timestamp: 60000, Value: 1}}}}
+	if report := Compare(prommodel.Matrix{stream(up, 1, 1)}, split, Config{}); !report.Equal() || report.Matched != 1 {
+		t.Errorf("split series were not merged: %+v", report)
+	}
+}
+
+func TestCompareSamples(t *testing.T) {
+	up := prommodel.Metric{prommodel.MetricNameLabel: "up"}
+	report := Compare(
+		prommodel.Matrix{stream(up, 1, 2, 3)},
+		prommodel.Matrix{{Metric: up, Values: []prommodel.SamplePair{{Timestamp: 0, Value: 1}, {Timestamp: 60000, Value: 5}, {Timestamp: 90000, Value: 3}}}},
+		Config{},
+	)
+	if len(report.Different) != 1 {
+		t.Fatalf("expected one different series, got %+v", report.Different)
+	}
+	d := report.Different[0]
+	if d.LeftSamples != 3 || d.RightSamples != 3 || d.MissingSamples != 1 || d.ExtraSamples != 1 || d.ValueDiffs != 1 || d.MaxDelta != "3" {
+		t.Errorf("unexpected series diff %+v", d)
+	}
+	if d.FirstDelta == nil || d.FirstDelta.Timestamp.UnixMilli() != 60000 || d.FirstDelta.Left != "2" || d.FirstDelta.Right != "5" {
+		t.Errorf("unexpected first delta %+v", d.FirstDelta)
+	}
+}
+
+func TestCompareTolerance(t *testing.T) {
+	cases := []struct {
+		left, right float64
+		cfg         Config
+		equal       bool
+	}{
+		{1, 1, Config{}, true},
+		{1, 1.5, Config{}, false},
+		{1, 1.5, Config{Tolerance: 0.5}, true},
+		{1, 1.6, Config{Tolerance: 0.5}, false},
+		{100, 101, Config{RelativeTolerance: 0.01}, true},
+		{100, 102, Config{RelativeTolerance: 0.01}, false},
+		// the relative tolerance is relative to the left value
+		{-100, -99, Config{RelativeTolerance: 0.01}, true},
+		{0, 0.001, Config{RelativeTolerance: 0.5}, false},
+		{100, 102, Config{Tolerance: 2, RelativeTolerance: 0.001}, true},
+		{math.NaN(), math.NaN(), Config{}, true},
+		{math.NaN(), 1, Config{Tolerance: math.Inf(1)}, false},
+		{math.Inf(1), math.Inf(1), Config{}, true},
+		{math.Inf(1), math.Inf(-1), Config{}, false},
+	}
+	for _, c := range cases {
+		report := Compare(
+			prommodel.Matrix{stream(prommodel.Metric{prommodel.MetricNameLabel: "up"}, c.left)},
+			prommodel.Matrix{stream(prommodel.Metric{prommodel.MetricNameLabel: "up"}, c.right)},
+			c.cfg,
+		)
+		if report.Equal() != c.equal {
+			t.Errorf("%g and %g with %+v: equal is %v, want %v", c.left, c.right, c.cfg, report.Equal(), c.equal)
+		}
+	}
+}
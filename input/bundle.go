@@ -0,0 +1,79 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package input
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/sapcc/promdump/compressor"
+	"github.com/sapcc/promdump/manifest"
+	"github.com/sapcc/promdump/output"
+)
+
+// the ustar magic of tar headers, at offset 257 of the first block
+var tarMagic = []byte("ustar")
+
+func isTar(path string, data []byte) bool {
+	if output.IsTar(path) {
+		return true
+	}
+	return len(data) >= 512 && bytes.Equal(data[257:257+len(tarMagic)], tarMagic)
+}
+
+// readBundle reads all entries of a tar bundle written with --bundle into a
+// single dump, format and layout of the first entry are reported for the whole bundle.
+func readBundle(path string, data []byte, compression compressor.Compression, cfg Config) (*Dump, error) {
+	dump := &Dump{Path: path, Compression: compression}
+	archive := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(archive)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", header.Name, err)
+		}
+		if header.Name == manifest.BundleFile {
+			var m manifest.Manifest
+			if err := json.Unmarshal(content, &m); err != nil {
+				return nil, fmt.Errorf("failed to parse manifest: %w", err)
+			}
+			dump.Manifest = &m
+			continue
+		}
+		format, layout, samples, err := decode(header.Name, content, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", header.Name, err)
+		}
+		if dump.Format == "" {
+			dump.Format, dump.Layout = format, layout
+		}
+		dump.Samples = append(dump.Samples, samples...)
+	}
+	if dump.Format == "" {
+		return nil, fmt.Errorf("no data files found in tar archive")
+	}
+	return dump, nil
+}
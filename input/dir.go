@@ -0,0 +1,114 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package input
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sapcc/promdump/manifest"
+	"github.com/sapcc/promdump/model"
+	"github.com/sapcc/promdump/output"
+)
+
+// readDir reads a hive style partitioned directory written with
+// --partition-by. Files starting with . or _ are skipped like hive does.
+func readDir(path string, cfg Config) (*Dump, error) {
+	if cfg.Layout != "" && cfg.Layout != model.LayoutFlat {
+		return nil, fmt.Errorf("partitioned directories only have the flat layout")
+	}
+	dump := &Dump{Path: path, Format: cfg.Format, Layout: model.LayoutFlat, Compression: cfg.Compression}
+	err := filepath.WalkDir(path, func(current string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if current == path {
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), ".") || strings.HasPrefix(entry.Name(), "_") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(path, current)
+		if err != nil {
+			return err
+		}
+		samples, err := dump.readPart(current, filepath.ToSlash(rel), cfg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		dump.Samples = append(dump.Samples, samples...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if dump.Format == "" {
+		return nil, fmt.Errorf("no part files found")
+	}
+	marshaled, err := os.ReadFile(filepath.Join(path, manifest.DirectoryFile))
+	if os.IsNotExist(err) {
+		return dump, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the marker is left empty when the dump was written without a manifest
+	if len(marshaled) == 0 {
+		return dump, nil
+	}
+	var m manifest.Manifest
+	if err := json.Unmarshal(marshaled, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	dump.Manifest = &m
+	return dump, nil
+}
+
+// readPart reads a single part file, the format and compression of the
+// first part are reported for the whole directory.
+func (dump *Dump) readPart(path, rel string, cfg Config) (model.SampleDumps, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, compression, err := decompress(file, cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+	format := cfg.Format
+	if format == "" {
+		format, _ = output.Infer(rel)
+	}
+	if format == "" {
+		format, err = model.DetectFormat(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if dump.Format == "" {
+		dump.Format, dump.Compression = format, compression
+	}
+	return model.UnmarshalPart(rel, data, format)
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package input
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sapcc/promdump/compressor"
+	"github.com/sapcc/promdump/manifest"
+	"github.com/sapcc/promdump/model"
+	"github.com/sapcc/promdump/output"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// Config overrides the detection of a dump, empty fields are detected.
+type Config struct {
+	Format      model.Format
+	Layout      model.Layout
+	Compression compressor.Compression
+}
+
+// Dump is a dump file read back into memory.
+type Dump struct {
+	Path        string
+	Format      model.Format
+	Layout      model.Layout
+	Compression compressor.Compression
+	Samples     model.SampleDumps
+	// Manifest is embedded into parquet files or read from the sidecar, it is nil if there is none.
+	Manifest *manifest.Manifest
+}
+
+// Read reads the dump at path, which is stdin for -. The compression is
+// detected from magic bytes and the format from the extension or the content.
+// Partitioned directories and tar bundles are read as a whole.
+func Read(path string, cfg Config) (*Dump, error) {
+	var dump *Dump
+	var err error
+	if info, statErr := os.Stat(path); path != "-" && statErr == nil && info.IsDir() {
+		dump, err = readDir(path, cfg)
+	} else {
+		dump, err = readFile(path, cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return dump, nil
+}
+
+func readFile(path string, cfg Config) (*Dump, error) {
+	var file io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		file = f
+	}
+	data, compression, err := decompress(file, cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+	if isTar(path, data) {
+		return readBundle(path, data, compression, cfg)
+	}
+	dump := &Dump{Path: path, Compression: compression}
+	dump.Format, dump.Layout, dump.Samples, err = decode(path, data, cfg)
+	if err != nil {
+		return nil, err
+	}
+	dump.Manifest, err = readManifest(path, data, dump.Format)
+	if err != nil {
+		return nil, err
+	}
+	return dump, nil
+}
+
+func decompress(r io.Reader, compression compressor.Compression) ([]byte, compressor.Compression, error) {
+	decompressed, compression, err := compressor.NewReader(r, compression)
+	if err != nil {
+		return nil, "", err
+	}
+	defer decompressed.Close()
+	data, err := io.ReadAll(decompressed)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s: %w", compression, err)
+	}
+	return data, compression, nil
+}
+
+// decode detects format and layout of data unless they are configured and
+// unmarshals it, name is used to infer the format from its extension.
+func decode(name string, data []byte, cfg Config) (model.Format, model.Layout, model.SampleDumps, error) {
+	format, layout := cfg.Format, cfg.Layout
+	if format == "" {
+		format, _ = output.Infer(name)
+	}
+	var err error
+	if format == "" {
+		format, err = model.DetectFormat(data)
+		if err != nil {
+			return "", "", nil, err
+		}
+	}
+	if layout == "" {
+		layout, err = model.DetectLayout(data, format)
+		if err != nil {
+			return "", "", nil, err
+		}
+	}
+	samples, err := model.UnmarshalSampleDumps(data, layout, format)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return format, layout, samples, nil
+}
+
+// ReadTable reads the rows of a table written by one of the catalog
+// commands, like Read it detects compression and format.
+func ReadTable(path string, cfg Config) ([]map[string]interface{}, error) {
+	var file io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		file = f
+	}
+	data, _, err := decompress(file, cfg.Compression)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	format := cfg.Format
+	if format == "" {
+		format, _ = output.Infer(path)
+	}
+	if format == "" {
+		format, err = model.DetectFormat(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	rows, err := model.UnmarshalRows(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rows, nil
+}
+
+// ReadAll reads all dumps at paths with the same config.
+func ReadAll(paths []string, cfg Config) ([]*Dump, error) {
+	dumps := make([]*Dump, 0, len(paths))
+	for _, path := range paths {
+		dump, err := Read(path, cfg)
+		if err != nil {
+			return nil, err
+		}
+		dumps = append(dumps, dump)
+	}
+	return dumps, nil
+}
+
+func readManifest(path string, data []byte, format model.Format) (*manifest.Manifest, error) {
+	var marshaled []byte
+	if format == model.FormatParquet {
+		pr, err := reader.NewParquetReader(buffer.NewBufferFileFromBytes(data), nil, 1)
+		if err != nil {
+			return nil, err
+		}
+		defer pr.ReadStop()
+		for _, kv := range pr.Footer.KeyValueMetadata {
+			if kv.Key == manifest.ParquetKey && kv.Value != nil {
+				marshaled = []byte(*kv.Value)
+			}
+		}
+	}
+	if marshaled == nil && path != "-" {
+		var err error
+		marshaled, err = os.ReadFile(manifest.SidecarPath(path))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if marshaled == nil {
+		return nil, nil
+	}
+	var m manifest.Manifest
+	if err := json.Unmarshal(marshaled, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return &m, nil
+}
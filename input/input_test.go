@@ -0,0 +1,158 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package input
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	prommodel "github.com/prometheus/common/model"
+	"github.com/sapcc/promdump/compressor"
+	"github.com/sapcc/promdump/manifest"
+	"github.com/sapcc/promdump/model"
+	"github.com/sapcc/promdump/output"
+)
+
+func testMatrix() prommodel.Matrix {
+	return prommodel.Matrix{
+		&prommodel.SampleStream{
+			Metric: prommodel.Metric{prommodel.MetricNameLabel: "up", "job": "node", "instance": "a:9100"},
+			Values: []prommodel.SamplePair{{Timestamp: 1696154400000, Value: 1}, {Timestamp: 1696240800000, Value: 0}},
+		},
+		&prommodel.SampleStream{
+			Metric: prommodel.Metric{prommodel.MetricNameLabel: "node_load1", "job": "node", "instance": "b:9100"},
+			Values: []prommodel.SamplePair{{Timestamp: 1696154400000, Value: 0.25}},
+		},
+	}
+}
+
+func sortedMatrix(matrix prommodel.Matrix) prommodel.Matrix {
+	sort.Slice(matrix, func(i, j int) bool { return matrix[i].Metric.Before(matrix[j].Metric) })
+	return matrix
+}
+
+func checkDump(t *testing.T, dump *Dump, format model.Format, layout model.Layout, compression compressor.Compression) {
+	t.Helper()
+	if dump.Format != format || dump.Layout != layout || dump.Compression != compression {
+		t.Errorf("%s: read as %s %s %s, want %s %s %s", dump.Path, dump.Format, dump.Layout, dump.Compression, format, layout, compression)
+	}
+	if actual := dump.Samples.AsMatrix(); !reflect.DeepEqual(sortedMatrix(actual), sortedMatrix(testMatrix())) {
+		t.Errorf("%s: read back\n%v\nwant\n%v", dump.Path, actual, testMatrix())
+	}
+	if dump.Manifest == nil || dump.Manifest.Version != "test" {
+		t.Errorf("%s: manifest was not read: %+v", dump.Path, dump.Manifest)
+	}
+}
+
+func TestReadPartitionedDirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dataset")
+	dir, err := output.CreateDir(path, false, manifest.DirectoryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := model.PartitionConfig{Keys: []string{model.PartitionMetric, model.PartitionDate}, Format: model.FormatCSV}
+	err = model.MarshalPartitioned([]prommodel.Value{testMatrix()}, cfg, func(part model.Part) error {
+		file, err := dir.Create(part.Path + ".zst")
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writer, err := compressor.NewWriter(file, "zstd")
+		if err != nil {
+			return err
+		}
+		if err := part.Write(writer); err != nil {
+			return err
+		}
+		return writer.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{manifest.DirectoryFile: `{"version":"test"}`, ".hidden": "garbage", "_SUCCESS": ""} {
+		file, err := dir.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.WriteString(content)
+		file.Close()
+	}
+	if err := dir.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	dump, err := Read(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDump(t, dump, model.FormatCSV, model.LayoutFlat, compressor.CompressionZstd)
+
+	// directories written without a manifest only have an empty marker
+	if err := os.WriteFile(filepath.Join(path, manifest.DirectoryFile), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dump, err = Read(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dump.Manifest != nil {
+		t.Errorf("expected no manifest for an empty marker, got %+v", dump.Manifest)
+	}
+
+	if _, err := Read(path, Config{Layout: model.LayoutNested}); err == nil {
+		t.Error("expected the nested layout to be rejected for a directory")
+	}
+	if _, err := Read(t.TempDir(), Config{}); err == nil {
+		t.Error("expected an empty directory to be rejected")
+	}
+}
+
+func TestReadBundle(t *testing.T) {
+	for _, path := range []string{"dump.tar.gz", "dump.unknown"} {
+		// marshaling removes the metric names
+		matrix := testMatrix()
+		path = filepath.Join(t.TempDir(), path)
+		out, err := output.OpenCompressed(path, false, "gzip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle := output.NewBundle(out)
+		for i, format := range []model.Format{model.FormatParquet, model.FormatNDJSON} {
+			data, err := model.MarshalSlice([]prommodel.Value{prommodel.Matrix{matrix[i]}}, model.LayoutNested, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := bundle.Add(fmt.Sprintf("query-%d.%s", i, format), data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := bundle.Add(manifest.BundleFile, []byte(`{"version":"test"}`)); err != nil {
+			t.Fatal(err)
+		}
+		if err := bundle.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := out.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		dump, err := Read(path, Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkDump(t, dump, model.FormatParquet, model.LayoutNested, compressor.CompressionGzip)
+	}
+}
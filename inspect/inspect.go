@@ -0,0 +1,219 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inspect
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+	"github.com/sapcc/promdump/input"
+	"github.com/sapcc/promdump/manifest"
+)
+
+// Summary describes the content of a dump.
+type Summary struct {
+	Path        string             `json:"path"`
+	Format      string             `json:"format"`
+	Layout      string             `json:"layout"`
+	Compression string             `json:"compression"`
+	Series      int                `json:"series"`
+	Samples     int                `json:"samples"`
+	NaNs        int                `json:"nans"`
+	Start       *time.Time         `json:"start,omitempty"`
+	End         *time.Time         `json:"end,omitempty"`
+	Step        *Step              `json:"step,omitempty"`
+	Metrics     []Metric           `json:"metrics"`
+	Labels      []Label            `json:"labels"`
+	Manifest    *manifest.Manifest `json:"manifest,omitempty"`
+}
+
+// Step describes the intervals between consecutive samples of all series.
+type Step struct {
+	// Interval is the most common interval.
+	Interval string `json:"interval"`
+	Regular  bool   `json:"regular"`
+	// Irregular counts intervals that differ from Interval.
+	Irregular int    `json:"irregular"`
+	MaxGap    string `json:"maxGap"`
+}
+
+type Metric struct {
+	Name    string `json:"name"`
+	Series  int    `json:"series"`
+	Samples int    `json:"samples"`
+	NaNs    int    `json:"nans"`
+}
+
+type Label struct {
+	Name string `json:"name"`
+	// Values is the number of distinct values.
+	Values int `json:"values"`
+}
+
+// Summarize collects the summary of a dump read with input.Read.
+func Summarize(dump *input.Dump) Summary {
+	summary := Summary{
+		Path:        dump.Path,
+		Format:      string(dump.Format),
+		Layout:      string(dump.Layout),
+		Compression: string(dump.Compression),
+		Samples:     len(dump.Samples),
+		Metrics:     make([]Metric, 0),
+		Labels:      make([]Label, 0),
+		Manifest:    dump.Manifest,
+	}
+	metrics := make(map[string]*Metric)
+	labels := make(map[string]map[string]struct{})
+	minTimestamp, maxTimestamp := int64(math.MaxInt64), int64(math.MinInt64)
+	matrix := dump.Samples.AsMatrix()
+	summary.Series = len(matrix)
+	intervals := make(map[int64]int)
+	var maxGap int64
+	for _, stream := range matrix {
+		name := string(stream.Metric[prommodel.MetricNameLabel])
+		metric, ok := metrics[name]
+		if !ok {
+			metric = &Metric{Name: name}
+			metrics[name] = metric
+		}
+		metric.Series++
+		metric.Samples += len(stream.Values)
+		for label, value := range stream.Metric {
+			if label == prommodel.MetricNameLabel {
+				continue
+			}
+			if labels[string(label)] == nil {
+				labels[string(label)] = make(map[string]struct{})
+			}
+			labels[string(label)][string(value)] = struct{}{}
+		}
+		timestamps := make([]int64, 0, len(stream.Values))
+		for _, pair := range stream.Values {
+			if math.IsNaN(float64(pair.Value)) {
+				metric.NaNs++
+				summary.NaNs++
+			}
+			timestamps = append(timestamps, int64(pair.Timestamp))
+		}
+		sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+		for i, timestamp := range timestamps {
+			minTimestamp = min64(minTimestamp, timestamp)
+			maxTimestamp = max64(maxTimestamp, timestamp)
+			if i > 0 {
+				interval := timestamp - timestamps[i-1]
+				intervals[interval]++
+				maxGap = max64(maxGap, interval)
+			}
+		}
+	}
+	if summary.Samples > 0 {
+		start, end := time.UnixMilli(minTimestamp).UTC(), time.UnixMilli(maxTimestamp).UTC()
+		summary.Start, summary.End = &start, &end
+	}
+	if len(intervals) > 0 {
+		var step int64
+		total := 0
+		for interval, count := range intervals {
+			total += count
+			if count > intervals[step] || count == intervals[step] && interval < step {
+				step = interval
+			}
+		}
+		summary.Step = &Step{
+			Interval:  (time.Duration(step) * time.Millisecond).String(),
+			Regular:   len(intervals) == 1,
+			Irregular: total - intervals[step],
+			MaxGap:    (time.Duration(maxGap) * time.Millisecond).String(),
+		}
+	}
+	for _, metric := range metrics {
+		summary.Metrics = append(summary.Metrics, *metric)
+	}
+	sort.Slice(summary.Metrics, func(i, j int) bool { return summary.Metrics[i].Name < summary.Metrics[j].Name })
+	for name, values := range labels {
+		summary.Labels = append(summary.Labels, Label{Name: name, Values: len(values)})
+	}
+	sort.Slice(summary.Labels, func(i, j int) bool { return summary.Labels[i].Name < summary.Labels[j].Name })
+	return summary
+}
+
+// WriteText writes the summary in a human readable form.
+func (s *Summary) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "file:\t%s\n", s.Path)
+	fmt.Fprintf(tw, "format:\t%s\n", s.Format)
+	fmt.Fprintf(tw, "layout:\t%s\n", s.Layout)
+	fmt.Fprintf(tw, "compression:\t%s\n", s.Compression)
+	fmt.Fprintf(tw, "series:\t%d\n", s.Series)
+	fmt.Fprintf(tw, "samples:\t%d\n", s.Samples)
+	fmt.Fprintf(tw, "NaN samples:\t%d\n", s.NaNs)
+	if s.Start != nil {
+		fmt.Fprintf(tw, "time range:\t%s - %s (%s)\n", s.Start.Format(time.RFC3339), s.End.Format(time.RFC3339), s.End.Sub(*s.Start))
+	}
+	if s.Step != nil {
+		regularity := "regular"
+		if !s.Step.Regular {
+			regularity = fmt.Sprintf("irregular, %d other intervals, max gap %s", s.Step.Irregular, s.Step.MaxGap)
+		}
+		fmt.Fprintf(tw, "step:\t%s (%s)\n", s.Step.Interval, regularity)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "METRIC\tSERIES\tSAMPLES\tNANS")
+	for _, metric := range s.Metrics {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", metric.Name, metric.Series, metric.Samples, metric.NaNs)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "LABEL\tVALUES")
+	for _, label := range s.Labels {
+		fmt.Fprintf(tw, "%s\t%d\n", label.Name, label.Values)
+	}
+	if s.Manifest != nil {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "manifest:")
+		fmt.Fprintf(tw, "  created:\t%s by promdump %s\n", s.Manifest.CreatedAt.Format(time.RFC3339), s.Manifest.Version)
+		for _, q := range s.Manifest.Queries {
+			fmt.Fprintf(tw, "  query %s:\t%s\n", q.Alias, q.Expr)
+		}
+		for _, u := range s.Manifest.URLs {
+			fmt.Fprintf(tw, "  url %s:\t%s\n", u.Alias, u.URL)
+		}
+		if s.Manifest.Timerange != nil {
+			fmt.Fprintf(tw, "  time range:\t%s - %s, step %s\n", s.Manifest.Timerange.Start.Format(time.RFC3339), s.Manifest.Timerange.End.Format(time.RFC3339), s.Manifest.Timerange.Step)
+		}
+		for _, warning := range s.Manifest.Warnings {
+			fmt.Fprintf(tw, "  warning:\t%s\n", strings.Join([]string{warning.URL, warning.Query, warning.Message}, ": "))
+		}
+	}
+	return tw.Flush()
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
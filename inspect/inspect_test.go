@@ -0,0 +1,104 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inspect
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+	"github.com/sapcc/promdump/input"
+	"github.com/sapcc/promdump/model"
+)
+
+const start = 1696154400000
+
+func sample(metric, instance string, offset int64, value float64) model.SampleDump {
+	return model.SampleDump{
+		Metric:    metric,
+		Labels:    prommodel.LabelSet{"instance": prommodel.LabelValue(instance), "job": "node"},
+		Timestamp: start + offset,
+		Value:     value,
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	dump := &input.Dump{
+		Path:   "dump.json",
+		Format: model.FormatJSON,
+		Layout: model.LayoutFlat,
+		Samples: model.SampleDumps{
+			sample("up", "a", 0, 1),
+			sample("up", "a", 60000, 1),
+			sample("up", "a", 120000, math.NaN()),
+			sample("up", "b", 0, 0),
+			sample("up", "b", 60000, 1),
+			// a missed scrape
+			sample("up", "b", 180000, 1),
+			sample("load", "a", 30000, 0.5),
+		},
+	}
+	summary := Summarize(dump)
+	if summary.Series != 3 || summary.Samples != 7 || summary.NaNs != 1 {
+		t.Errorf("counted %d series, %d samples and %d NaNs, want 3, 7 and 1", summary.Series, summary.Samples, summary.NaNs)
+	}
+	metrics := []Metric{
+		{Name: "load", Series: 1, Samples: 1},
+		{Name: "up", Series: 2, Samples: 6, NaNs: 1},
+	}
+	if !reflect.DeepEqual(summary.Metrics, metrics) {
+		t.Errorf("metrics are %+v, want %+v", summary.Metrics, metrics)
+	}
+	labels := []Label{{Name: "instance", Values: 2}, {Name: "job", Values: 1}}
+	if !reflect.DeepEqual(summary.Labels, labels) {
+		t.Errorf("labels are %+v, want %+v", summary.Labels, labels)
+	}
+	if summary.Start == nil || !summary.Start.Equal(time.UnixMilli(start)) {
+		t.Errorf("start is %v, want %v", summary.Start, time.UnixMilli(start).UTC())
+	}
+	if summary.End == nil || !summary.End.Equal(time.UnixMilli(start+180000)) {
+		t.Errorf("end is %v, want %v", summary.End, time.UnixMilli(start+180000).UTC())
+	}
+	step := Step{Interval: "1m0s", Regular: false, Irregular: 1, MaxGap: "2m0s"}
+	if summary.Step == nil || *summary.Step != step {
+		t.Errorf("step is %+v, want %+v", summary.Step, step)
+	}
+	if err := summary.WriteText(&bytes.Buffer{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSummarizeWithoutSeries(t *testing.T) {
+	summary := Summarize(&input.Dump{Path: "empty.json", Format: model.FormatJSON, Layout: model.LayoutFlat})
+	if summary.Series != 0 || summary.Samples != 0 || summary.NaNs != 0 {
+		t.Errorf("counted %d series, %d samples and %d NaNs in an empty dump", summary.Series, summary.Samples, summary.NaNs)
+	}
+	if summary.Start != nil || summary.End != nil || summary.Step != nil {
+		t.Errorf("expected no time range or step, got %v - %v, %+v", summary.Start, summary.End, summary.Step)
+	}
+	// both are marshaled as empty json arrays
+	if summary.Metrics == nil || summary.Labels == nil {
+		t.Error("expected empty metrics and labels")
+	}
+	buf := bytes.Buffer{}
+	if err := summary.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("time range")) {
+		t.Errorf("wrote a time range for an empty dump:\n%s", buf.String())
+	}
+}
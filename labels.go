@@ -0,0 +1,72 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/sapcc/promdump/client"
+	"github.com/sapcc/promdump/model"
+	"github.com/sapcc/promdump/query"
+)
+
+type labelsConfig struct {
+	http     client.HTTPConfig
+	output   outputConfig
+	promURLs []query.Target
+	lookup   query.LabelsConfig
+	table    bool
+}
+
+// labels writes one row per label name, or per label value if names are given.
+// The server column is only added for multiple prometheis.
+func labels(ctx context.Context, w io.Writer, cfg labelsConfig) error {
+	if model.Layout(cfg.output.layout) != model.LayoutFlat {
+		return fmt.Errorf("labels only support the flat layout")
+	}
+	httpClient, err := client.MakeHTTPClient(cfg.http)
+	if err != nil {
+		return err
+	}
+	out, err := cfg.output.openCatalog(w, cfg.table)
+	if err != nil {
+		return err
+	}
+	defer out.abort()
+	labels, err := query.Labels(ctx, cfg.promURLs, cfg.lookup, &httpClient)
+	if err != nil {
+		return err
+	}
+	columns := []string{"label"}
+	if len(cfg.promURLs) > 1 {
+		columns = append([]string{"server"}, columns...)
+	}
+	if len(cfg.lookup.Names) > 0 {
+		columns = append(columns, "value")
+	}
+	table := model.NewTable(columns...)
+	for _, label := range labels {
+		row := map[string]interface{}{"server": label.Target.Alias, "label": label.Name}
+		if label.Values == nil {
+			table.Add(row)
+			continue
+		}
+		for _, value := range label.Values {
+			table.Add(map[string]interface{}{"server": label.Target.Alias, "label": label.Name, "value": value})
+		}
+	}
+	return out.write(table, cfg.promURLs)
+}
@@ -14,54 +14,132 @@ limitations under the License.
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/ilmari-lauhakangas/go-curl"
+	prommodel "github.com/prometheus/common/model"
+	"github.com/sapcc/promdump/api"
 	"github.com/sapcc/promdump/client"
 	"github.com/sapcc/promdump/compressor"
+	"github.com/sapcc/promdump/diff"
+	"github.com/sapcc/promdump/input"
+	"github.com/sapcc/promdump/inspect"
+	"github.com/sapcc/promdump/manifest"
 	"github.com/sapcc/promdump/model"
+	"github.com/sapcc/promdump/output"
 	"github.com/sapcc/promdump/query"
+	"github.com/sapcc/promdump/store"
 	"github.com/urfave/cli/v2"
 )
 
 var version string
 
 func main() {
-	signalCtx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	signalCtx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 	now := time.Now()
 	app := cli.App{
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:    "backend",
-				Usage:   "http backend to use",
+				Usage:   "http backend to use, can be go, curl or replay",
 				Aliases: []string{"b"},
 			},
 			&cli.StringFlag{
 				Name:  "client-cert",
 				Usage: "name of client cert to use",
 			},
+			&cli.StringFlag{
+				Name:  "auth",
+				Usage: "authentication to use, can be keystone or sigv4",
+			},
+			&cli.StringFlag{
+				Name:  "os-cloud",
+				Usage: "clouds.yaml entry to use for keystone authentication, defaults to $OS_CLOUD or the OS_* environment variables",
+			},
+			&cli.StringFlag{
+				Name:  "sigv4-region",
+				Usage: "aws region for sigv4 signing, defaults to the region of the aws environment or profile",
+			},
+			&cli.StringFlag{
+				Name:  "sigv4-service",
+				Value: client.DefaultSigV4Service,
+				Usage: "aws service name for sigv4 signing",
+			},
+			&cli.BoolFlag{
+				Name:  "debug-http",
+				Usage: "log every http request with its timings to stderr",
+			},
+			&cli.StringFlag{
+				Name:  "debug-http-format",
+				Value: string(client.DebugText),
+				Usage: "format of the http debug log, can be text or json",
+			},
+			&cli.StringFlag{
+				Name:  "cassette",
+				Usage: "directory to record responses to with --record or to replay them from with --backend replay",
+			},
+			&cli.BoolFlag{
+				Name:  "record",
+				Usage: "record all responses to the --cassette directory",
+			},
+			&cli.StringSliceFlag{
+				Name:  "proxy",
+				Usage: "proxy for all requests or TARGET=PROXY for requests to a single prometheus url or host, PROXY can be direct",
+			},
 			&cli.StringFlag{
 				Name:    "format",
 				Value:   "json",
 				Aliases: []string{"f"},
+				Usage:   "serialization format, can be json, ndjson, csv or parquet",
 			},
 			&cli.StringFlag{
 				Name:    "layout",
 				Value:   "flat",
 				Aliases: []string{"l"},
+				Usage:   "data layout, can be raw, nested or flat",
 			},
 			&cli.StringFlag{
 				Name:    "compress",
 				Value:   "none",
 				Aliases: []string{"c"},
+				Usage:   "compression with optional level, e.g. gzip or zstd:19",
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "file to write to instead of stdout, format and compression are inferred from extensions like .csv.gz",
+			},
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "overwrite an existing --output",
+			},
+			&cli.StringSliceFlag{
+				Name:  "partition-by",
+				Usage: "write a hive style partitioned directory to --output, partitioned by metric, date and/or label names, e.g. metric,date",
+			},
+			&cli.IntFlag{
+				Name:  "max-rows-per-file",
+				Usage: "split partitions into files of at most this many rows, 0 means unlimited",
+			},
+			&cli.StringFlag{
+				Name:  "bundle",
+				Usage: "write a tar archive with one file per query or per query and url, can be query or query-url, defaults to query for --output ending in .tar or .tar.gz",
+			},
+			&cli.StringFlag{
+				Name:  "manifest",
+				Usage: "where to put the manifest describing a dump, can be sidecar, embed (parquet only) or none, defaults to sidecar with --output",
 			},
 			&cli.TimestampFlag{
 				Name:    "start",
@@ -101,12 +179,12 @@ func main() {
 		Commands: []*cli.Command{
 			{
 				Name:      "dump",
-				ArgsUsage: "query all given prometheis",
+				ArgsUsage: "queries, optionally as ALIAS=QUERY, to run against all given prometheis",
 				Flags: []cli.Flag{
 					&cli.StringSliceFlag{
 						Name:     "url",
 						Required: true,
-						Usage:    "prometheis to query",
+						Usage:    "prometheis to query, optionally as ALIAS=URL",
 						Aliases:  []string{"u"},
 					},
 				},
@@ -114,36 +192,383 @@ func main() {
 					if !ctx.Args().Present() {
 						return fmt.Errorf("no query given")
 					}
+					if err := fixedWindow(ctx, "start", "end"); err != nil {
+						return err
+					}
 					return dump(signalCtx, dumpConfig{
-						promURLs:    ctx.StringSlice("url"),
-						backend:     ctx.String("backend"),
-						clientCert:  ctx.String("client-cert"),
-						format:      ctx.String("format"),
-						layout:      ctx.String("layout"),
-						compression: ctx.String("compress"),
-						start:       *ctx.Timestamp("start"),
-						end:         *ctx.Timestamp("end"),
-						step:        ctx.Duration("step"),
-						queries:     ctx.Args().Slice(),
+						promURLs: query.ParseTargets(ctx.StringSlice("url")),
+						http:     httpConfig(ctx),
+						output:   outputFlags(ctx),
+						start:    *ctx.Timestamp("start"),
+						end:      *ctx.Timestamp("end"),
+						step:     ctx.Duration("step"),
+						queries:  query.ParseQueries(ctx.Args().Slice()),
 					})
 				},
-				Usage: "Dumps data from a prometheus to stdout",
+				Usage: "Dumps data from a prometheus to stdout or --output",
 			},
 			{
 				Name:      "metrics",
-				ArgsUsage: "prometheus url",
+				ArgsUsage: "prometheus urls, optionally as ALIAS=URL",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:    "url",
+						Usage:   "prometheis to list the metrics of in addition to the arguments, optionally as ALIAS=URL",
+						Aliases: []string{"u"},
+					},
+					&cli.BoolFlag{
+						Name:  "table",
+						Usage: "print a table with one row per metric instead of writing --format",
+					},
+					&cli.BoolFlag{
+						Name:  "partial",
+						Usage: "only list metrics missing from some prometheis or with labels missing from some of them",
+					},
+					sinceFlag("look up labels and --match selectors"),
+					&cli.StringSliceFlag{
+						Name:  "match",
+						Usage: "series selectors, only metrics with matching series are listed",
+					},
+					&cli.StringSliceFlag{
+						Name:  "name",
+						Usage: "regexes, only metrics with a name fully matching one of them are listed",
+					},
+					&cli.IntFlag{
+						Name:  "concurrency",
+						Value: 8,
+						Usage: "maximum number of concurrent label lookups across all prometheis",
+					},
+					&cli.BoolFlag{
+						Name:  "no-labels",
+						Usage: "skip the label lookups and only query the metadata endpoint",
+					},
+					&cli.StringFlag{
+						Name:  "cardinality",
+						Usage: "add cardinality statistics, series counts the series per metric within --since and the distinct values per label exactly, tsdb only the series per metric in the head block for the 10 metrics with the most series",
+					},
+					&cli.IntFlag{
+						Name:  "top",
+						Value: 10,
+						Usage: "number of values with the most series listed per label, -1 lists all, only for --cardinality series",
+					},
+				},
 				Action: func(ctx *cli.Context) error {
-					if !ctx.Args().Present() {
+					urls := append(ctx.Args().Slice(), ctx.StringSlice("url")...)
+					if len(urls) == 0 {
 						return fmt.Errorf("no prometheus given")
 					}
-					return metrics(signalCtx, metricsConfig{
-						backend:    ctx.String("backend"),
-						clientCert: ctx.String("client-cert"),
-						promURL:    ctx.Args().First(),
+					if ctx.Bool("partial") && len(urls) < 2 {
+						return fmt.Errorf("--partial compares prometheis and needs at least two of them")
+					}
+					if err := fixedWindow(ctx, "end"); err != nil {
+						return err
+					}
+					lookup, err := metricsFlags(ctx)
+					if err != nil {
+						return err
+					}
+					return metrics(signalCtx, ctx.App.Writer, metricsConfig{
+						http:     httpConfig(ctx),
+						output:   outputFlags(ctx),
+						promURLs: query.ParseTargets(urls),
+						lookup:   lookup,
+						partial:  ctx.Bool("partial"),
+						table:    ctx.Bool("table"),
 					})
 
 				},
-				Usage: "Dumps available metrics and their labels to stdout",
+				Usage: "Dumps available metrics and their labels to stdout or --output",
+			},
+			{
+				Name:      "labels",
+				ArgsUsage: "label names to list the values of, all label names are listed if none are given",
+				Flags: catalogCommandFlags(
+					&cli.StringSliceFlag{
+						Name:  "match",
+						Usage: "series selectors restricting the series the labels are taken from",
+					},
+					sinceFlag("look up labels"),
+				),
+				Action: func(ctx *cli.Context) error {
+					if err := fixedWindow(ctx, "end"); err != nil {
+						return err
+					}
+					start, end := catalogWindow(ctx)
+					return labels(signalCtx, ctx.App.Writer, labelsConfig{
+						http:     httpConfig(ctx),
+						output:   outputFlags(ctx),
+						promURLs: query.ParseTargets(ctx.StringSlice("url")),
+						lookup: query.LabelsConfig{
+							Names:   ctx.Args().Slice(),
+							Matches: ctx.StringSlice("match"),
+							Start:   start,
+							End:     end,
+						},
+						table: ctx.Bool("table"),
+					})
+				},
+				Usage: "Dumps label names or the values of labels within --since to stdout or --output",
+			},
+			{
+				Name:      "series",
+				ArgsUsage: "series selectors to match",
+				Flags:     catalogCommandFlags(sinceFlag("look up series")),
+				Action: func(ctx *cli.Context) error {
+					if !ctx.Args().Present() {
+						return fmt.Errorf("no series selector given")
+					}
+					if err := fixedWindow(ctx, "end"); err != nil {
+						return err
+					}
+					start, end := catalogWindow(ctx)
+					return series(signalCtx, ctx.App.Writer, seriesConfig{
+						http:     httpConfig(ctx),
+						output:   outputFlags(ctx),
+						promURLs: query.ParseTargets(ctx.StringSlice("url")),
+						lookup: query.SeriesConfig{
+							Matches: ctx.Args().Slice(),
+							Start:   start,
+							End:     end,
+						},
+						table: ctx.Bool("table"),
+					})
+				},
+				Usage: "Dumps the label sets of series matching selectors within --since to stdout or --output",
+			},
+			{
+				Name:  "rules",
+				Flags: catalogCommandFlags(),
+				Action: func(ctx *cli.Context) error {
+					return rules(signalCtx, ctx.App.Writer, catalogFlags(ctx))
+				},
+				Usage: "Dumps the recording and alerting rules with their health to stdout or --output",
+			},
+			{
+				Name:  "alerts",
+				Flags: catalogCommandFlags(),
+				Action: func(ctx *cli.Context) error {
+					return alerts(signalCtx, ctx.App.Writer, catalogFlags(ctx))
+				},
+				Usage: "Dumps the active alerts to stdout or --output",
+			},
+			{
+				Name: "targets",
+				Flags: catalogCommandFlags(
+					&cli.StringFlag{
+						Name:  "state",
+						Value: "active",
+						Usage: "targets to list, can be active, dropped or any",
+					},
+					&cli.StringSliceFlag{
+						Name:  "health",
+						Usage: "only list active targets with this health, can be up, down or unknown, dropped targets are kept with --state any",
+					},
+					&cli.StringSliceFlag{
+						Name:  "job",
+						Usage: "only list targets of this job",
+					},
+					&cli.BoolFlag{
+						Name:  "metadata",
+						Usage: "add the number of metrics with metadata of each target from /api/v1/targets/metadata",
+					},
+				),
+				Action: func(ctx *cli.Context) error {
+					return targets(signalCtx, ctx.App.Writer, targetsConfig{
+						catalogConfig: catalogFlags(ctx),
+						state:         ctx.String("state"),
+						health:        ctx.StringSlice("health"),
+						jobs:          ctx.StringSlice("job"),
+						metadata:      ctx.Bool("metadata"),
+					})
+				},
+				Usage: "Dumps the scrape targets with their health to stdout or --output",
+			},
+			{
+				Name: "status",
+				Flags: catalogCommandFlags(
+					&cli.IntFlag{
+						Name:  "top",
+						Value: 5,
+						Usage: "number of metrics and labels with the most series or values to list, at most 10",
+					},
+				),
+				Action: func(ctx *cli.Context) error {
+					return status(signalCtx, ctx.App.Writer, statusConfig{
+						catalogConfig: catalogFlags(ctx),
+						top:           ctx.Int("top"),
+					})
+				},
+				Usage: "Dumps the version, retention and tsdb head statistics of prometheis to stdout or --output",
+			},
+			{
+				Name:      "convert",
+				ArgsUsage: "dump files to convert, - reads stdin",
+				Flags:     inputCommandFlags(),
+				Action: func(ctx *cli.Context) error {
+					if !ctx.Args().Present() {
+						return fmt.Errorf("no input given")
+					}
+					return convert(convertConfig{
+						inputs: ctx.Args().Slice(),
+						input:  inputFlags(ctx),
+						output: outputFlags(ctx),
+					})
+				},
+				Usage: "Converts dump files to another layout, format or compression",
+			},
+			{
+				Name:      "inspect",
+				ArgsUsage: "dump files to inspect, - reads stdin",
+				Flags: append([]cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the summary as json",
+					},
+				}, inputCommandFlags()...),
+				Action: func(ctx *cli.Context) error {
+					if !ctx.Args().Present() {
+						return fmt.Errorf("no input given")
+					}
+					return inspectDumps(ctx.App.Writer, ctx.Args().Slice(), inputFlags(ctx), ctx.Bool("json"))
+				},
+				Usage: "Summarizes the content of dump files",
+			},
+			{
+				Name:      "serve",
+				ArgsUsage: "dump files to serve",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:  "listen",
+						Value: "localhost:9099",
+						Usage: "address to serve the prometheus api on, e.g. :9099 for all interfaces",
+					},
+					&cli.StringSliceFlag{
+						Name:  "metadata",
+						Usage: "metrics catalogs written by the metrics command to serve the type, help and unit of metrics from",
+					},
+					&cli.DurationFlag{
+						Name:  "query-timeout",
+						Value: 2 * time.Minute,
+						Usage: "maximum duration of a single query",
+					},
+				}, inputCommandFlags()...),
+				Action: func(ctx *cli.Context) error {
+					if !ctx.Args().Present() {
+						return fmt.Errorf("no input given")
+					}
+					return serve(signalCtx, serveConfig{
+						inputs:   ctx.Args().Slice(),
+						input:    inputFlags(ctx),
+						metadata: ctx.StringSlice("metadata"),
+						listen:   ctx.String("listen"),
+						timeout:  ctx.Duration("query-timeout"),
+					})
+				},
+				Usage: "Serves dump files through the prometheus query api",
+			},
+			{
+				Name:      "eval",
+				ArgsUsage: "dump files to evaluate the queries over",
+				Flags: append([]cli.Flag{
+					&cli.StringSliceFlag{
+						Name:     "query",
+						Required: true,
+						Usage:    "promql expressions to evaluate, optionally as ALIAS=QUERY",
+						Aliases:  []string{"q"},
+					},
+					&cli.BoolFlag{
+						Name:  "instant",
+						Usage: "evaluate instant queries at --time instead of range queries from --start to --end",
+					},
+					&cli.TimestampFlag{
+						Name:   "time",
+						Layout: "2006-01-02T15:04:05",
+						Usage:  "UTC timestamp of instant queries, defaults to the newest sample",
+					},
+					&cli.DurationFlag{
+						Name:  "query-timeout",
+						Value: 2 * time.Minute,
+						Usage: "maximum duration of a single query",
+					},
+				}, inputCommandFlags()...),
+				Action: func(ctx *cli.Context) error {
+					if !ctx.Args().Present() {
+						return fmt.Errorf("no input given")
+					}
+					cfg := evalConfig{
+						inputs:  ctx.Args().Slice(),
+						input:   inputFlags(ctx),
+						output:  outputFlags(ctx),
+						queries: query.ParseQueries(ctx.StringSlice("query")),
+						timeout: ctx.Duration("query-timeout"),
+						step:    ctx.Duration("step"),
+					}
+					// the defaults of the global flags are relative to now, which rarely fits a dump
+					if ctx.IsSet("start") {
+						cfg.start = ctx.Timestamp("start")
+					}
+					if ctx.IsSet("end") {
+						cfg.end = ctx.Timestamp("end")
+					}
+					if ctx.Bool("instant") {
+						cfg.step = 0
+						cfg.start, cfg.end = ctx.Timestamp("time"), ctx.Timestamp("time")
+					}
+					return eval(signalCtx, cfg)
+				},
+				Usage: "Evaluates promql over dump files and writes the results to stdout or --output",
+			},
+			{
+				Name:      "diff",
+				ArgsUsage: "two dump files or prometheus urls to compare, - reads stdin",
+				Flags: append([]cli.Flag{
+					&cli.StringSliceFlag{
+						Name:    "query",
+						Usage:   "queries to run against urls from --start to --end, required if a url is given",
+						Aliases: []string{"q"},
+					},
+					&cli.Float64Flag{
+						Name:  "tolerance",
+						Usage: "absolute difference up to which values are equal",
+					},
+					&cli.Float64Flag{
+						Name:  "relative-tolerance",
+						Usage: "difference relative to the left value up to which values are equal, e.g. 0.01",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the differences as json",
+					},
+				}, inputCommandFlags()...),
+				OnUsageError: func(ctx *cli.Context, err error, isSubcommand bool) error {
+					return diffExit(err)
+				},
+				Action: func(ctx *cli.Context) error {
+					if ctx.NArg() != 2 {
+						return diffExit(fmt.Errorf("expected two dump files or urls, got %d", ctx.NArg()))
+					}
+					if strings.Contains(ctx.Args().Get(0), "://") || strings.Contains(ctx.Args().Get(1), "://") {
+						if err := fixedWindow(ctx, "start", "end"); err != nil {
+							return diffExit(err)
+						}
+					}
+					return diffDumps(signalCtx, ctx.App.Writer, diffConfig{
+						left:    ctx.Args().Get(0),
+						right:   ctx.Args().Get(1),
+						http:    httpConfig(ctx),
+						input:   inputFlags(ctx),
+						queries: query.ParseQueries(ctx.StringSlice("query")),
+						start:   *ctx.Timestamp("start"),
+						end:     *ctx.Timestamp("end"),
+						step:    ctx.Duration("step"),
+						compare: diff.Config{
+							Tolerance:         ctx.Float64("tolerance"),
+							RelativeTolerance: ctx.Float64("relative-tolerance"),
+						},
+						asJSON: ctx.Bool("json"),
+					})
+				},
+				Usage: "Compares two dumps or prometheis and exits with 1 if they differ and 2 on errors",
 			},
 			{
 				Name: "version",
@@ -161,63 +586,520 @@ func main() {
 	}
 }
 
-type dumpConfig struct {
-	queries     []string
-	promURLs    []string
-	backend     string
+func httpConfig(ctx *cli.Context) client.HTTPConfig {
+	debug := client.DebugOff
+	if ctx.Bool("debug-http") {
+		debug = client.DebugFormat(ctx.String("debug-http-format"))
+	}
+	return client.HTTPConfig{
+		Backend:      client.HTTPBackend(ctx.String("backend")),
+		ClientCert:   ctx.String("client-cert"),
+		Auth:         client.AuthMode(ctx.String("auth")),
+		OSCloud:      ctx.String("os-cloud"),
+		SigV4Region:  ctx.String("sigv4-region"),
+		SigV4Service: ctx.String("sigv4-service"),
+		Proxies:      ctx.StringSlice("proxy"),
+		Debug:        debug,
+		Cassette:     ctx.String("cassette"),
+		Record:       ctx.Bool("record"),
+	}
+}
+
+// fixedWindow rejects time flags left at their defaults relative to now while
+// recording or replaying a cassette, since the requests would never match again.
+func fixedWindow(ctx *cli.Context, flags ...string) error {
+	if !ctx.Bool("record") && ctx.String("backend") != string(client.BackendReplay) {
+		return nil
+	}
+	for _, flag := range flags {
+		if !ctx.IsSet(flag) {
+			return fmt.Errorf("--%s defaults to a time relative to now, which cannot be replayed, set it explicitly to use a cassette", flag)
+		}
+	}
+	return nil
+}
+
+type outputConfig struct {
+	path        string
+	force       bool
 	format      string
 	layout      string
 	compression string
-	clientCert  string
-	start       time.Time
-	end         time.Time
-	step        time.Duration
+	manifest    manifest.Mode
+	partitionBy []string
+	maxRows     int
+	bundle      string
+}
+
+const (
+	bundleQuery    = "query"
+	bundleQueryURL = "query-url"
+)
+
+// outputFlags infers format and compression from the output path unless they are set explicitly.
+func outputFlags(ctx *cli.Context) outputConfig {
+	cfg := outputConfig{
+		path:        ctx.String("output"),
+		force:       ctx.Bool("force"),
+		format:      ctx.String("format"),
+		layout:      ctx.String("layout"),
+		compression: ctx.String("compress"),
+		manifest:    manifest.Mode(ctx.String("manifest")),
+		partitionBy: ctx.StringSlice("partition-by"),
+		maxRows:     ctx.Int("max-rows-per-file"),
+		bundle:      ctx.String("bundle"),
+	}
+	if cfg.bundle == "" && output.IsTar(cfg.path) {
+		cfg.bundle = bundleQuery
+	}
+	if cfg.partitioned() {
+		if !ctx.IsSet("format") {
+			cfg.format = model.FormatParquet
+		}
+		return cfg
+	}
+	format, compression := output.Infer(cfg.path)
+	if format != "" && !ctx.IsSet("format") {
+		cfg.format = string(format)
+	}
+	if compression != "" && !ctx.IsSet("compress") {
+		cfg.compression = string(compression)
+	}
+	return cfg
+}
+
+func (cfg *outputConfig) toStdout() bool {
+	return cfg.path == "" || cfg.path == "-"
+}
+
+func (cfg *outputConfig) partitioned() bool {
+	return len(cfg.partitionBy) > 0
+}
+
+func (cfg *outputConfig) open() (*output.Compressed, error) {
+	return output.OpenCompressed(cfg.path, cfg.force, cfg.compression)
+}
+
+// manifestMode resolves the auto mode and checks that the mode fits the output.
+func (cfg *outputConfig) manifestMode() (manifest.Mode, error) {
+	switch cfg.manifest {
+	case manifest.ModeAuto:
+		if cfg.bundle != "" {
+			return manifest.ModeSidecar, nil
+		}
+		if cfg.toStdout() {
+			return manifest.ModeNone, nil
+		}
+		if cfg.partitioned() {
+			return manifest.ModeSidecar, nil
+		}
+		return manifest.ModeSidecar, output.CheckOverwrite(manifest.SidecarPath(cfg.path), cfg.force)
+	case manifest.ModeNone:
+	case manifest.ModeSidecar:
+		if cfg.bundle != "" {
+			return cfg.manifest, nil
+		}
+		if cfg.toStdout() {
+			return "", fmt.Errorf("a sidecar manifest needs --output")
+		}
+		if cfg.partitioned() {
+			return cfg.manifest, nil
+		}
+		return cfg.manifest, output.CheckOverwrite(manifest.SidecarPath(cfg.path), cfg.force)
+	case manifest.ModeEmbed:
+		if model.Format(cfg.format) != model.FormatParquet {
+			return "", fmt.Errorf("manifests can only be embedded into parquet")
+		}
+	default:
+		return "", fmt.Errorf("unknown manifest mode: %s", cfg.manifest)
+	}
+	return cfg.manifest, nil
+}
+
+// describe fills the output settings into m.
+func (cfg *outputConfig) describe(m *manifest.Manifest) {
+	m.Version = version
+	m.Format = cfg.format
+	m.Layout = cfg.layout
+	m.Compression = cfg.compression
+}
+
+type dumpConfig struct {
+	queries  []query.Query
+	promURLs []query.Target
+	http     client.HTTPConfig
+	output   outputConfig
+	start    time.Time
+	end      time.Time
+	step     time.Duration
 }
 
 func dump(ctx context.Context, cfg dumpConfig) error {
-	httpClient := client.MakeHTTPClient(client.HTTPBackend(cfg.backend), cfg.clientCert)
-	result, err := query.Product(ctx, query.ProductQueryConfig{
+	httpClient, err := client.MakeHTTPClient(cfg.http)
+	if err != nil {
+		return err
+	}
+	out, err := cfg.output.openSink()
+	if err != nil {
+		return err
+	}
+	defer out.abort()
+	results, dumpManifest, err := cfg.run(ctx, &httpClient)
+	if err != nil {
+		return err
+	}
+	return out.writeResults(results, cfg.queries, dumpManifest)
+}
+
+// run queries all prometheis and describes the results.
+func (cfg *dumpConfig) run(ctx context.Context, httpClient *http.Client) ([]query.Result, manifest.Manifest, error) {
+	timerange := query.Timerange{
+		Start: cfg.start,
+		End:   cfg.end,
+		Step:  cfg.step,
+	}
+	results, err := query.Product(ctx, query.ProductQueryConfig{
 		MultiQueryConfig: query.MultiQueryConfig{
-			Timerange: query.Timerange{
-				Start: cfg.start,
-				End:   cfg.end,
-				Step:  cfg.step,
-			},
-			Queries: cfg.queries,
+			Timerange: timerange,
+			Queries:   cfg.queries,
 		},
 		URLs: cfg.promURLs,
-	}, &httpClient)
+	}, httpClient)
+	if err != nil {
+		return nil, manifest.Manifest{}, err
+	}
+	dumpManifest := manifest.FromResults(results, timerange)
+	cfg.output.describe(&dumpManifest)
+	return results, dumpManifest, nil
+}
+
+// inputCommandFlags are the flags of the commands reading dumps, see inputFlags.
+func inputCommandFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "input-format",
+			Usage: "format of the input, detected from the extension or content by default",
+		},
+		&cli.StringFlag{
+			Name:  "input-layout",
+			Usage: "layout of the input, detected from the content by default",
+		},
+		&cli.StringFlag{
+			Name:  "input-compress",
+			Usage: "compression of the input, detected from the content by default",
+		},
+	}
+}
+
+func inputFlags(ctx *cli.Context) input.Config {
+	return input.Config{
+		Format:      model.Format(ctx.String("input-format")),
+		Layout:      model.Layout(ctx.String("input-layout")),
+		Compression: compressor.Compression(ctx.String("input-compress")),
+	}
+}
+
+type convertConfig struct {
+	inputs []string
+	input  input.Config
+	output outputConfig
+}
+
+func convert(cfg convertConfig) error {
+	out, err := cfg.output.openSink()
 	if err != nil {
 		return err
 	}
-	marshaled, err := model.MarshalSlice(result, model.Layout(cfg.layout), model.Format(cfg.format))
+	defer out.abort()
+	dumps, err := input.ReadAll(cfg.inputs, cfg.input)
 	if err != nil {
 		return err
 	}
-	compressed, err := compressor.Compress(marshaled, compressor.Compression(cfg.compression))
+	values := make([]prommodel.Value, 0, len(dumps))
+	manifests := make([]*manifest.Manifest, 0, len(dumps))
+	for _, in := range dumps {
+		values = append(values, in.Samples.AsMatrix())
+		manifests = append(manifests, in.Manifest)
+	}
+	// queries, urls and warnings of the inputs are kept
+	convertManifest := manifest.Merge(manifests)
+	for _, value := range values {
+		convertManifest.AddCounts(value)
+	}
+	cfg.output.describe(&convertManifest)
+	return out.write(values, convertManifest)
+}
+
+func inspectDumps(w io.Writer, paths []string, cfg input.Config, asJSON bool) error {
+	summaries := make([]inspect.Summary, 0, len(paths))
+	for _, path := range paths {
+		dump, err := input.Read(path, cfg)
+		if err != nil {
+			return err
+		}
+		summaries = append(summaries, inspect.Summarize(dump))
+	}
+	if asJSON {
+		marshaled, err := json.MarshalIndent(summaries, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", marshaled)
+		return err
+	}
+	for i, summary := range summaries {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if err := summary.WriteText(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type serveConfig struct {
+	inputs   []string
+	input    input.Config
+	metadata []string
+	listen   string
+	timeout  time.Duration
+}
+
+// loadStore reads all dumps into a store.
+func loadStore(paths []string, cfg input.Config) (*store.Store, error) {
+	dumps, err := input.ReadAll(paths, cfg)
 	if err != nil {
+		return nil, err
+	}
+	samples := store.New()
+	for _, in := range dumps {
+		samples.Add(in.Samples.AsMatrix())
+	}
+	samples.Sort()
+	return samples, nil
+}
+
+// loadMetadata reads the type, help and unit of metrics from catalogs
+// written by the metrics command, in any layout and format.
+func loadMetadata(paths []string) (map[string][]api.Metadata, error) {
+	metadata := make(map[string][]api.Metadata)
+	for _, path := range paths {
+		rows, err := input.ReadTable(path, input.Config{})
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			name := tableString(row, "metric")
+			if name == "" {
+				// the nested layout keeps the fields of the metric
+				name = tableString(row, "name")
+			}
+			_, isDump := row["timestamp"]
+			if name == "" || isDump {
+				return nil, fmt.Errorf("%s is not a metrics catalog written by the metrics command", path)
+			}
+			entry := api.Metadata{Type: tableString(row, "type"), Help: tableString(row, "help"), Unit: tableString(row, "unit")}
+			if entry.Type == "" {
+				entry.Type = "unknown"
+			}
+			known := false
+			for _, existing := range metadata[name] {
+				known = known || existing == entry
+			}
+			if !known {
+				metadata[name] = append(metadata[name], entry)
+			}
+		}
+	}
+	return metadata, nil
+}
+
+func tableString(row map[string]interface{}, column string) string {
+	if val, ok := row[column].(string); ok {
+		return val
+	}
+	return ""
+}
+
+func serve(ctx context.Context, cfg serveConfig) error {
+	samples, err := loadStore(cfg.inputs, cfg.input)
+	if err != nil {
+		return err
+	}
+	metadata, err := loadMetadata(cfg.metadata)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Addr: cfg.listen,
+		Handler: (&api.API{
+			Store:    samples,
+			Engine:   store.NewEngine(cfg.timeout),
+			Metadata: metadata,
+			Version:  version,
+		}).Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	start, end := samples.Timerange()
+	fmt.Fprintf(os.Stderr, "serving %d series from %s to %s on %s\n", samples.Series(), start.Format(time.RFC3339), end.Format(time.RFC3339), cfg.listen)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx) //nolint:errcheck
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
 		return err
 	}
-	_, err = io.Copy(os.Stdout, bytes.NewBuffer(compressed))
-	return err
+	return nil
 }
 
-type metricsConfig struct {
-	backend    string
-	promURL    string
-	clientCert string
+type evalConfig struct {
+	inputs  []string
+	input   input.Config
+	output  outputConfig
+	queries []query.Query
+	timeout time.Duration
+	// start and end default to the time range of the inputs if nil, step is 0 for instant queries
+	start *time.Time
+	end   *time.Time
+	step  time.Duration
 }
 
-func metrics(ctx context.Context, cfg metricsConfig) error {
-	httpClient := client.MakeHTTPClient(client.HTTPBackend(cfg.backend), cfg.clientCert)
-	metrics, err := query.MetricsWithLabels(ctx, cfg.promURL, &httpClient)
+func eval(ctx context.Context, cfg evalConfig) error {
+	out, err := cfg.output.openSink()
 	if err != nil {
 		return err
 	}
-	marshaled, err := json.Marshal(metrics)
+	defer out.abort()
+	samples, err := loadStore(cfg.inputs, cfg.input)
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(os.Stdout, bytes.NewBuffer(marshaled))
-	return err
+	timerange := query.Timerange{Step: cfg.step}
+	timerange.Start, timerange.End = samples.Timerange()
+	if cfg.start != nil {
+		timerange.Start = *cfg.start
+	}
+	if cfg.end != nil {
+		timerange.End = *cfg.end
+	}
+	if cfg.step == 0 {
+		timerange.Start = timerange.End
+	}
+	engine := store.NewEngine(cfg.timeout)
+	results := make([]query.Result, 0, len(cfg.queries))
+	for _, q := range cfg.queries {
+		matrix, warnings, err := samples.Eval(ctx, engine, q.Expr, timerange.Start, timerange.End, timerange.Step)
+		if err != nil {
+			return fmt.Errorf("failed to evaluate %s: %w", q.Alias, err)
+		}
+		result := query.Result{Target: query.Target{Alias: "local"}, Query: q, Value: matrix}
+		for _, warning := range warnings {
+			result.Warnings = append(result.Warnings, warning.Error())
+		}
+		results = append(results, result)
+	}
+	evalManifest := manifest.FromResults(results, timerange)
+	// the inputs take the place of prometheus urls
+	evalManifest.URLs = make([]manifest.URL, 0, len(cfg.inputs))
+	for _, path := range cfg.inputs {
+		evalManifest.URLs = append(evalManifest.URLs, manifest.URL{Alias: filepath.Base(path), URL: path})
+	}
+	cfg.output.describe(&evalManifest)
+	return out.writeResults(results, cfg.queries, evalManifest)
+}
+
+type diffConfig struct {
+	left    string
+	right   string
+	http    client.HTTPConfig
+	input   input.Config
+	queries []query.Query
+	start   time.Time
+	end     time.Time
+	step    time.Duration
+	compare diff.Config
+	asJSON  bool
+}
+
+// diffDumps exits like diff(1): with 1 if the dumps differ and with 2 on errors.
+func diffDumps(ctx context.Context, w io.Writer, cfg diffConfig) error {
+	equal, err := compareDumps(ctx, w, cfg)
+	if err != nil {
+		return diffExit(err)
+	}
+	if !equal {
+		return cli.Exit("", 1)
+	}
+	return nil
+}
+
+// diffExit turns err into an exit with 2, so errors are distinguishable from differences.
+func diffExit(err error) error {
+	var exitCoder cli.ExitCoder
+	if err == nil || errors.As(err, &exitCoder) {
+		return err
+	}
+	return cli.Exit(fmt.Sprintf("error: %s", err), 2)
+}
+
+func compareDumps(ctx context.Context, w io.Writer, cfg diffConfig) (bool, error) {
+	left, err := cfg.load(ctx, "left", cfg.left)
+	if err != nil {
+		return false, err
+	}
+	right, err := cfg.load(ctx, "right", cfg.right)
+	if err != nil {
+		return false, err
+	}
+	report := diff.Compare(left, right, cfg.compare)
+	report.Left, report.Right = cfg.left, cfg.right
+	if cfg.asJSON {
+		marshaled, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return false, err
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", marshaled); err != nil {
+			return false, err
+		}
+	} else if err := report.WriteText(w); err != nil {
+		return false, err
+	}
+	return report.Equal(), nil
+}
+
+// load queries source if it is a url and reads it as dump file otherwise.
+func (cfg *diffConfig) load(ctx context.Context, alias, source string) (prommodel.Matrix, error) {
+	if !strings.Contains(source, "://") {
+		in, err := input.Read(source, cfg.input)
+		if err != nil {
+			return nil, err
+		}
+		return in.Samples.AsMatrix(), nil
+	}
+	if len(cfg.queries) == 0 {
+		return nil, fmt.Errorf("no query given for %s", source)
+	}
+	httpClient, err := client.MakeHTTPClient(cfg.http)
+	if err != nil {
+		return nil, err
+	}
+	results, err := query.Multi(ctx, query.Target{Alias: alias, URL: source}, query.MultiQueryConfig{
+		Timerange: query.Timerange{Start: cfg.start, End: cfg.end, Step: cfg.step},
+		Queries:   cfg.queries,
+	}, &httpClient)
+	if err != nil {
+		return nil, err
+	}
+	matrix := make(prommodel.Matrix, 0)
+	for _, result := range results {
+		resultMatrix, ok := result.Value.(prommodel.Matrix)
+		if !ok {
+			return nil, fmt.Errorf("query result is not a matrix for: %s", result.Query.Expr)
+		}
+		matrix = append(matrix, resultMatrix...)
+	}
+	return matrix, nil
 }
@@ -0,0 +1,570 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	prommodel "github.com/prometheus/common/model"
+	"github.com/sapcc/promdump/api"
+	"github.com/sapcc/promdump/input"
+	"github.com/sapcc/promdump/inspect"
+	"github.com/sapcc/promdump/manifest"
+	"github.com/sapcc/promdump/model"
+	"github.com/sapcc/promdump/query"
+	"github.com/urfave/cli/v2"
+)
+
+func TestOutputFlagsInference(t *testing.T) {
+	run := func(args ...string) outputConfig {
+		var cfg outputConfig
+		app := &cli.App{
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "format", Aliases: []string{"f"}, Value: "json"},
+				&cli.StringFlag{Name: "layout", Value: "flat"},
+				&cli.StringFlag{Name: "compress", Aliases: []string{"c"}, Value: "none"},
+				&cli.StringFlag{Name: "output", Aliases: []string{"o"}},
+				&cli.BoolFlag{Name: "force"},
+			},
+			Action: func(ctx *cli.Context) error {
+				cfg = outputFlags(ctx)
+				return nil
+			},
+		}
+		if err := app.Run(append([]string{"promdump"}, args...)); err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+	cases := []struct {
+		args        []string
+		format      string
+		compression string
+	}{
+		{nil, "json", "none"},
+		{[]string{"-o", "-"}, "json", "none"},
+		{[]string{"-o", "dump.csv.gz"}, "csv", "gzip"},
+		{[]string{"-o", "dump.parquet", "-c", "zstd"}, "parquet", "zstd"},
+		{[]string{"-o", "dump.csv.gz", "-f", "ndjson", "-c", "gzip:9"}, "ndjson", "gzip:9"},
+		{[]string{"-o", "dump.xz", "-f", "csv"}, "csv", "xz"},
+		{[]string{"-o", "dump.out", "-f", "csv"}, "csv", "none"},
+	}
+	for _, c := range cases {
+		cfg := run(c.args...)
+		if cfg.format != c.format || cfg.compression != c.compression {
+			t.Errorf("%v: format %s and compression %s, want %s and %s", c.args, cfg.format, cfg.compression, c.format, c.compression)
+		}
+	}
+}
+
+func writeTestDump(t *testing.T, name string) string {
+	t.Helper()
+	data, err := model.MarshalSlice(testValues(), model.LayoutFlat, model.FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestInspectJSONIsAlwaysAnArray(t *testing.T) {
+	path := writeTestDump(t, "dump.json")
+	for _, paths := range [][]string{{path}, {path, path}} {
+		buf := bytes.Buffer{}
+		if err := inspectDumps(&buf, paths, input.Config{}, true); err != nil {
+			t.Fatal(err)
+		}
+		var summaries []inspect.Summary
+		if err := json.Unmarshal(buf.Bytes(), &summaries); err != nil {
+			t.Fatalf("%d files: %s", len(paths), err)
+		}
+		if len(summaries) != len(paths) {
+			t.Errorf("expected %d summaries, got %d", len(paths), len(summaries))
+		}
+	}
+}
+
+func TestLoadMetadata(t *testing.T) {
+	dir := t.TempDir()
+	flat := model.NewTable("server", "metric", "type", "unit", "help", "label")
+	flat.Add(map[string]interface{}{"server": "a", "metric": "up", "type": "gauge", "help": "Up.", "label": "job"})
+	flat.Add(map[string]interface{}{"server": "a", "metric": "up", "type": "gauge", "help": "Up.", "label": "instance"})
+	flat.Add(map[string]interface{}{"server": "b", "metric": "up", "type": "gauge", "help": "Up, but older."})
+	flat.Add(map[string]interface{}{"server": "b", "metric": "http_requests", "type": "counter", "unit": "requests"})
+	paths := make([]string, 0)
+	for _, format := range []model.Format{model.FormatCSV, model.FormatParquet} {
+		path := filepath.Join(dir, "metrics."+string(format))
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := model.WriteTable(file, flat, format, nil); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		paths = append(paths, path)
+	}
+	// the nested layout of the metrics command, for metadata without a type
+	nested := filepath.Join(dir, "metrics.ndjson")
+	if err := os.WriteFile(nested, []byte(`{"name":"node_load1","type":"","unit":"","help":"Load.","helps":["Load."],"labels":["job"]}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := loadMetadata(append(paths, nested))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]api.Metadata{
+		"up":            {{Type: "gauge", Help: "Up."}, {Type: "gauge", Help: "Up, but older."}},
+		"http_requests": {{Type: "counter", Unit: "requests"}},
+		"node_load1":    {{Type: "unknown", Help: "Load."}},
+	}
+	if !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("got %v, want %v", metadata, expected)
+	}
+
+	if _, err := loadMetadata([]string{writeTestDump(t, "dump.json")}); err == nil {
+		t.Error("expected a dump to be rejected as metrics catalog")
+	}
+}
+
+// fakeCatalog serves the metadata of up and node_load1 and the label names of up.
+func fakeCatalog(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v1/metadata":
+			fmt.Fprint(w, `{"status":"success","data":{
+				"up":[{"type":"gauge","help":"Up.","unit":""}],
+				"node_load1":[{"type":"gauge","help":"Load.","unit":""}]
+			}}`)
+		case r.URL.Path == "/api/v1/labels" && r.FormValue("match[]") == "up":
+			fmt.Fprint(w, `{"status":"success","data":["instance","job"]}`)
+		case r.URL.Path == "/api/v1/labels":
+			fmt.Fprint(w, `{"status":"success","data":[]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writeMetricsCatalog(t *testing.T, cfg metricsConfig) (string, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "metrics."+cfg.output.format)
+	cfg.output.path, cfg.output.compression, cfg.output.manifest = path, "none", manifest.ModeNone
+	cfg.lookup.Concurrency = 2
+	return path, metrics(context.Background(), &bytes.Buffer{}, cfg)
+}
+
+func TestMetricsCatalogFlatRows(t *testing.T) {
+	server := fakeCatalog(t)
+	cases := []struct {
+		targets []query.Target
+		rows    []string
+	}{
+		{
+			[]query.Target{{Alias: "a", URL: server.URL}},
+			[]string{"node_load1 ", "up instance", "up job"},
+		},
+		{
+			[]query.Target{{Alias: "a", URL: server.URL}, {Alias: "b", URL: server.URL}},
+			[]string{"a node_load1 ", "a up instance", "a up job", "b node_load1 ", "b up instance", "b up job"},
+		},
+	}
+	for _, c := range cases {
+		path, err := writeMetricsCatalog(t, metricsConfig{
+			output:   outputConfig{format: string(model.FormatCSV), layout: string(model.LayoutFlat)},
+			promURLs: c.targets,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		table, err := input.ReadTable(path, input.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := make([]string, 0, len(table))
+		for _, row := range table {
+			fields := []string{tableString(row, "metric"), tableString(row, "label")}
+			if server, ok := row["server"]; ok {
+				fields = append([]string{server.(string)}, fields...)
+			}
+			rows = append(rows, strings.Join(fields, " "))
+		}
+		sort.Strings(rows)
+		if !reflect.DeepEqual(rows, c.rows) {
+			t.Errorf("%d prometheis: rows are %q, want %q", len(c.targets), rows, c.rows)
+		}
+	}
+}
+
+func TestMetricsCatalogNested(t *testing.T) {
+	server := fakeCatalog(t)
+	for _, format := range []model.Format{model.FormatJSON, model.FormatNDJSON} {
+		path, err := writeMetricsCatalog(t, metricsConfig{
+			output:   outputConfig{format: string(format), layout: string(model.LayoutNested)},
+			promURLs: []query.Target{{Alias: "a", URL: server.URL}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format == model.FormatNDJSON {
+			data = []byte("[" + strings.Join(strings.Split(strings.TrimSpace(string(data)), "\n"), ",") + "]")
+		}
+		var metrics []query.MetricDump
+		if err := json.Unmarshal(data, &metrics); err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		labels := make(map[string][]string)
+		for _, metric := range metrics {
+			labels[metric.Name] = metric.Labels
+		}
+		expected := map[string][]string{"up": {"instance", "job"}, "node_load1": {}}
+		if !reflect.DeepEqual(labels, expected) {
+			t.Errorf("%s: labels are %v, want %v", format, labels, expected)
+		}
+	}
+}
+
+func TestMetricsCatalogRejectsOutputs(t *testing.T) {
+	server := fakeCatalog(t)
+	cases := []struct {
+		name   string
+		output outputConfig
+		table  bool
+	}{
+		{"csv in the nested layout", outputConfig{format: string(model.FormatCSV), layout: string(model.LayoutNested)}, false},
+		{"--table with --output", outputConfig{format: string(model.FormatCSV), layout: string(model.LayoutFlat)}, true},
+	}
+	for _, c := range cases {
+		path, err := writeMetricsCatalog(t, metricsConfig{
+			output:   c.output,
+			promURLs: []query.Target{{Alias: "a", URL: server.URL}},
+			table:    c.table,
+		})
+		if err == nil {
+			t.Errorf("expected %s to be rejected", c.name)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left %s behind", c.name, filepath.Base(path))
+		}
+	}
+}
+
+func TestDiffExitCodes(t *testing.T) {
+	path := writeTestDump(t, "dump.json")
+	values := testValues()
+	values[0].(prommodel.Matrix)[0].Values[1].Value = 2
+	data, err := model.MarshalSlice(values, model.LayoutFlat, model.FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := filepath.Join(t.TempDir(), "changed.json")
+	if err := os.WriteFile(changed, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		left, right string
+		code        int
+	}{
+		{path, path, 0},
+		{path, changed, 1},
+		{path, filepath.Join(t.TempDir(), "missing.json"), 2},
+	}
+	for _, c := range cases {
+		err := diffDumps(context.Background(), &bytes.Buffer{}, diffConfig{left: c.left, right: c.right})
+		code := 0
+		if err != nil {
+			var exitCoder cli.ExitCoder
+			if !errors.As(err, &exitCoder) {
+				t.Fatalf("%s %s: %s is no exit code", c.left, c.right, err)
+			}
+			code = exitCoder.ExitCode()
+		}
+		if code != c.code {
+			t.Errorf("diff %s %s exited with %d, want %d", filepath.Base(c.left), filepath.Base(c.right), code, c.code)
+		}
+	}
+}
+
+func TestCatalogWindow(t *testing.T) {
+	run := func(args ...string) (time.Time, time.Time) {
+		var start, end time.Time
+		app := &cli.App{
+			Flags: []cli.Flag{
+				&cli.TimestampFlag{Name: "start", Aliases: []string{"s"}, Layout: "2006-01-02T15:04:05", Value: cli.NewTimestamp(time.Unix(0, 0))},
+				&cli.TimestampFlag{Name: "end", Layout: "2006-01-02T15:04:05", Value: cli.NewTimestamp(time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC))},
+			},
+			Commands: []*cli.Command{{
+				Name:  "labels",
+				Flags: []cli.Flag{sinceFlag("look up labels")},
+				Action: func(ctx *cli.Context) error {
+					start, end = catalogWindow(ctx)
+					return nil
+				},
+			}},
+		}
+		if err := app.Run(append([]string{"promdump"}, args...)); err != nil {
+			t.Fatal(err)
+		}
+		return start.UTC(), end.UTC()
+	}
+	noon := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		args       []string
+		start, end time.Time
+	}{
+		{[]string{"labels"}, noon.Add(-24 * time.Hour), noon},
+		{[]string{"labels", "--since", "1h"}, noon.Add(-time.Hour), noon},
+		{[]string{"labels", "--since", "0"}, time.UnixMilli(0).UTC(), noon},
+		{[]string{"--end", "2023-10-02T00:00:00", "labels", "--since", "2h"}, noon.Add(10 * time.Hour), noon.Add(12 * time.Hour)},
+		{[]string{"--start", "2023-10-01T11:30:00", "labels", "--since", "2h"}, noon.Add(-30 * time.Minute), noon},
+		{[]string{"-s", "2023-10-01T11:30:00", "labels"}, noon.Add(-30 * time.Minute), noon},
+	}
+	for _, c := range cases {
+		start, end := run(c.args...)
+		if !start.Equal(c.start) || !end.Equal(c.end) {
+			t.Errorf("%v: window %s - %s, want %s - %s", c.args, start, end, c.start, c.end)
+		}
+	}
+}
+
+func TestSeriesTableKeepsCollidingLabels(t *testing.T) {
+	cfg := seriesConfig{promURLs: []query.Target{{Alias: "eu"}, {Alias: "us"}}}
+	table := cfg.flatTable([]query.Series{
+		{Target: query.Target{Alias: "eu"}, Labels: prommodel.LabelSet{"__name__": "up", "server": "node-1", "metric": "cpu", "job": "node"}},
+		{Target: query.Target{Alias: "us"}, Labels: prommodel.LabelSet{"__name__": "up", "label_server": "x"}},
+	})
+	if expected := []string{"server", "metric", "job", "label_server", "label_metric", "label_label_server"}; !reflect.DeepEqual(table.Columns, expected) {
+		t.Errorf("columns %v, want %v", table.Columns, expected)
+	}
+	expected := []map[string]interface{}{
+		{"server": "eu", "metric": "up", "job": "node", "label_metric": "cpu", "label_label_server": "node-1"},
+		{"server": "us", "metric": "up", "label_server": "x"},
+	}
+	if !reflect.DeepEqual(table.Rows, expected) {
+		t.Errorf("rows %v, want %v", table.Rows, expected)
+	}
+
+	// a single prometheus has no server column to collide with
+	cfg.promURLs = cfg.promURLs[:1]
+	table = cfg.flatTable([]query.Series{{Labels: prommodel.LabelSet{"__name__": "up", "server": "node-1"}}})
+	if expected := []string{"metric", "server"}; !reflect.DeepEqual(table.Columns, expected) {
+		t.Errorf("columns %v, want %v", table.Columns, expected)
+	}
+}
+
+// fakeRules serves a recording and an alerting rule and a firing alert.
+func fakeRules(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/rules":
+			fmt.Fprint(w, `{"status":"success","data":{"groups":[{"name":"node","file":"node.yml","interval":60,"rules":[
+				{"type":"recording","name":"job:up:sum","query":"sum by (job) (up)","labels":{"team":"sre"},"health":"ok",
+					"evaluationTime":0.5,"lastEvaluation":"2023-10-01T10:00:00Z"},
+				{"type":"alerting","name":"InstanceDown","query":"up == 0","duration":300,"labels":{"severity":"page"},
+					"annotations":{},"alerts":[],"health":"err","lastError":"boom","state":"firing","evaluationTime":0.25,
+					"lastEvaluation":"2023-10-01T10:00:00Z"}
+			]}]}}`)
+		case "/api/v1/alerts":
+			fmt.Fprint(w, `{"status":"success","data":{"alerts":[
+				{"labels":{"alertname":"InstanceDown","instance":"a"},"annotations":{"summary":"a is down"},"state":"firing",
+					"activeAt":"2023-10-01T09:55:00Z","value":"0e+00"}
+			]}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// writeCatalog writes a catalog of a single prometheus in format and reads it back.
+func writeCatalog(t *testing.T, format model.Format, write func(ctx context.Context, w io.Writer, cfg catalogConfig) error) []map[string]interface{} {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "catalog."+string(format))
+	err := write(context.Background(), &bytes.Buffer{}, catalogConfig{
+		output: outputConfig{
+			path:        path,
+			format:      string(format),
+			layout:      string(model.LayoutFlat),
+			compression: "none",
+			manifest:    manifest.ModeNone,
+		},
+		promURLs: []query.Target{{Alias: "eu", URL: fakeRules(t).URL}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := input.ReadTable(path, input.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestRulesCatalog(t *testing.T) {
+	rows := writeCatalog(t, model.FormatCSV, rules)
+	// empty csv fields like the state of recording rules are read back as missing
+	expected := []map[string]interface{}{
+		{
+			"group": "node", "file": "node.yml", "interval": "60", "type": "recording", "rule": "job:up:sum",
+			"expr": "sum by (job) (up)", "labels": `{"team":"sre"}`, "health": "ok",
+			"evaluation_time": "0.5", "last_evaluation": "2023-10-01T10:00:00Z",
+		},
+		{
+			"group": "node", "file": "node.yml", "interval": "60", "type": "alerting", "rule": "InstanceDown",
+			"expr": "up == 0", "labels": `{"severity":"page"}`, "health": "err", "last_error": "boom",
+			"evaluation_time": "0.25", "last_evaluation": "2023-10-01T10:00:00Z", "state": "firing",
+		},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("rows are %v, want %v", rows, expected)
+	}
+}
+
+func TestAlertsCatalog(t *testing.T) {
+	rows := writeCatalog(t, model.FormatParquet, alerts)
+	if len(rows) != 1 {
+		t.Fatalf("expected one alert, got %v", rows)
+	}
+	row := rows[0]
+	for column, value := range map[string]string{"alertname": "InstanceDown", "state": "firing", "active_at": "2023-10-01T09:55:00Z", "value": "0e+00"} {
+		if row[column] != value {
+			t.Errorf("%s is %v, want %s", column, row[column], value)
+		}
+	}
+	if fmt.Sprint(row["labels"]) != fmt.Sprint(map[string]interface{}{"alertname": "InstanceDown", "instance": "a"}) {
+		t.Errorf("labels are %v", row["labels"])
+	}
+	if fmt.Sprint(row["annotations"]) != fmt.Sprint(map[string]interface{}{"summary": "a is down"}) {
+		t.Errorf("annotations are %v", row["annotations"])
+	}
+}
+
+func TestTargetsHealthOnlyFiltersActiveTargets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":{"activeTargets":[
+			{"labels":{"job":"node","instance":"up:9100"},"health":"up"},
+			{"labels":{"job":"node","instance":"down:9100"},"health":"down"}
+		],"droppedTargets":[{"discoveredLabels":{"job":"node","__address__":"dropped:9100"}}]}}`)
+	}))
+	defer server.Close()
+	list := func(state string, health ...string) ([]string, error) {
+		buf := bytes.Buffer{}
+		err := targets(context.Background(), &buf, targetsConfig{
+			catalogConfig: catalogConfig{
+				output:   outputConfig{layout: string(model.LayoutFlat)},
+				promURLs: []query.Target{{Alias: "eu", URL: server.URL}},
+				table:    true,
+			},
+			state:  state,
+			health: health,
+		})
+		instances := make([]string, 0)
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n")[1:] {
+			instances = append(instances, strings.Fields(line)[2])
+		}
+		return instances, err
+	}
+	cases := []struct {
+		state     string
+		health    []string
+		instances []string
+	}{
+		{"active", []string{"up"}, []string{"up:9100"}},
+		{"any", nil, []string{"up:9100", "down:9100", "dropped:9100"}},
+		{"any", []string{"up"}, []string{"up:9100", "dropped:9100"}},
+		{"dropped", nil, []string{"dropped:9100"}},
+	}
+	for _, c := range cases {
+		instances, err := list(c.state, c.health...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(instances, c.instances) {
+			t.Errorf("--state %s --health %v listed %v, want %v", c.state, c.health, instances, c.instances)
+		}
+	}
+	if _, err := list("dropped", "up"); err == nil {
+		t.Error("expected --health to be rejected for dropped targets")
+	}
+}
+
+func TestStatusRow(t *testing.T) {
+	current := query.Status{
+		Target: query.Target{Alias: "eu"},
+		TSDB: v1.TSDBResult{
+			HeadStats:               v1.TSDBHeadStats{NumSeries: 2, MinTime: 1696154400000, MaxTime: 1696161600000},
+			SeriesCountByMetricName: []v1.Stat{{Name: "up", Value: 2}, {Name: "load", Value: 1}},
+		},
+		Runtimeinfo: v1.RuntimeinfoResult{StorageRetention: "15d"},
+		Flags:       v1.FlagsResult{"storage.tsdb.retention.time": "30d", "storage.tsdb.retention.size": "10GB"},
+	}
+	row := statusRow(current, 1)
+	expected := map[string]interface{}{"retention": "15d", "retention_size": "10GB", "head_min_time": "2023-10-01T10:00:00Z",
+		"head_max_time": "2023-10-01T12:00:00Z", "top_metrics": "up=2", "start_time": nil}
+	for column, value := range expected {
+		if row[column] != value {
+			t.Errorf("%s is %v, want %v", column, row[column], value)
+		}
+	}
+
+	// older prometheis only report the retention as flag
+	current.Runtimeinfo.StorageRetention = ""
+	if row := statusRow(current, 1); row["retention"] != "30d" {
+		t.Errorf("retention is %v, want the flag", row["retention"])
+	}
+	// missing runtime info and flags leave the columns empty
+	current.Flags = nil
+	if row := statusRow(current, 1); row["retention"] != "" || row["retention_size"] != "" {
+		t.Errorf("expected empty retention columns, got %v and %v", row["retention"], row["retention_size"])
+	}
+
+	// an empty head reports inverted bounds, which are left out
+	current.TSDB.HeadStats = v1.TSDBHeadStats{MinTime: math.MaxInt64, MaxTime: math.MinInt64}
+	row = statusRow(current, 1)
+	if _, ok := row["head_min_time"]; ok {
+		t.Errorf("empty head has min time %v", row["head_min_time"])
+	}
+	if _, ok := row["head_max_time"]; ok {
+		t.Errorf("empty head has max time %v", row["head_max_time"])
+	}
+}
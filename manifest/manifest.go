@@ -0,0 +1,241 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manifest
+
+import (
+	"encoding/json"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+	"github.com/sapcc/promdump/output"
+	"github.com/sapcc/promdump/query"
+)
+
+type Mode string
+
+const (
+	// ModeAuto writes a sidecar when writing to a file and nothing otherwise.
+	ModeAuto    Mode = ""
+	ModeNone    Mode = "none"
+	ModeSidecar Mode = "sidecar"
+	ModeEmbed   Mode = "embed"
+)
+
+// ParquetKey is the key of the manifest in the key value metadata of parquet files.
+const ParquetKey = "promdump.manifest"
+
+const sidecarSuffix = ".manifest.json"
+
+// BundleFile is the name of the manifest inside of tar bundles, it is the last entry.
+const BundleFile = "manifest.json"
+
+// DirectoryFile is the name of the manifest inside of partitioned dumps.
+// Readers of hive style datasets skip files starting with an underscore.
+const DirectoryFile = "_promdump_manifest.json"
+
+type Query struct {
+	Alias string `json:"alias"`
+	Expr  string `json:"expr"`
+}
+
+type URL struct {
+	Alias string `json:"alias"`
+	URL   string `json:"url"`
+}
+
+type Timerange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Step  string    `json:"step"`
+}
+
+// File is a single data file of a partitioned dump.
+type File struct {
+	Path   string `json:"path"`
+	Rows   int    `json:"rows"`
+	SHA256 string `json:"sha256"`
+}
+
+type Warning struct {
+	URL     string `json:"url"`
+	Query   string `json:"query"`
+	Message string `json:"message"`
+}
+
+// Manifest describes how a dump was produced.
+type Manifest struct {
+	Version     string     `json:"version"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	Queries     []Query    `json:"queries"`
+	URLs        []URL      `json:"urls"`
+	Timerange   *Timerange `json:"timerange,omitempty"`
+	Format      string     `json:"format"`
+	Layout      string     `json:"layout"`
+	Compression string     `json:"compression"`
+	Series      int        `json:"series"`
+	Rows        int        `json:"rows"`
+	Warnings    []Warning  `json:"warnings"`
+	// SHA256 of the data file, which is unknown for embedded manifests.
+	SHA256 string `json:"sha256,omitempty"`
+	// Files lists the data files of partitioned dumps and bundles.
+	Files []File `json:"files,omitempty"`
+}
+
+// FromResults collects queries, urls, warnings and counts from results.
+func FromResults(results []query.Result, timerange query.Timerange) Manifest {
+	m := Manifest{
+		CreatedAt: time.Now().UTC(),
+		Queries:   make([]Query, 0),
+		URLs:      make([]URL, 0),
+		Warnings:  make([]Warning, 0),
+		Timerange: &Timerange{
+			Start: timerange.Start.UTC(),
+			End:   timerange.End.UTC(),
+			Step:  timerange.Step.String(),
+		},
+	}
+	seenQueries := make(map[string]struct{})
+	seenURLs := make(map[string]struct{})
+	for _, result := range results {
+		if _, ok := seenQueries[result.Query.Alias]; !ok {
+			seenQueries[result.Query.Alias] = struct{}{}
+			m.Queries = append(m.Queries, Query{Alias: result.Query.Alias, Expr: result.Query.Expr})
+		}
+		if _, ok := seenURLs[result.Target.Alias]; !ok {
+			seenURLs[result.Target.Alias] = struct{}{}
+			m.URLs = append(m.URLs, URL{Alias: result.Target.Alias, URL: result.Target.URL})
+		}
+		for _, warn := range result.Warnings {
+			m.Warnings = append(m.Warnings, Warning{URL: result.Target.Alias, Query: result.Query.Alias, Message: warn})
+		}
+		m.AddCounts(result.Value)
+	}
+	return m
+}
+
+// FromTargets describes catalog dumps like metrics or targets, which have no
+// queries. Counts are left empty.
+func FromTargets(targets []query.Target) Manifest {
+	m := Manifest{
+		CreatedAt: time.Now().UTC(),
+		Queries:   make([]Query, 0),
+		URLs:      make([]URL, 0, len(targets)),
+		Warnings:  make([]Warning, 0),
+	}
+	for _, target := range targets {
+		m.URLs = append(m.URLs, URL{Alias: target.Alias, URL: target.URL})
+	}
+	return m
+}
+
+// Merge combines the queries, urls, warnings and time ranges of manifests,
+// nil entries are skipped. Counts, output settings and files are left empty.
+func Merge(manifests []*Manifest) Manifest {
+	m := Manifest{
+		CreatedAt: time.Now().UTC(),
+		Queries:   make([]Query, 0),
+		URLs:      make([]URL, 0),
+		Warnings:  make([]Warning, 0),
+	}
+	seenQueries := make(map[Query]struct{})
+	seenURLs := make(map[URL]struct{})
+	for _, current := range manifests {
+		if current == nil {
+			continue
+		}
+		for _, q := range current.Queries {
+			if _, ok := seenQueries[q]; !ok {
+				seenQueries[q] = struct{}{}
+				m.Queries = append(m.Queries, q)
+			}
+		}
+		for _, u := range current.URLs {
+			if _, ok := seenURLs[u]; !ok {
+				seenURLs[u] = struct{}{}
+				m.URLs = append(m.URLs, u)
+			}
+		}
+		m.Warnings = append(m.Warnings, current.Warnings...)
+		if current.Timerange == nil {
+			continue
+		}
+		if m.Timerange == nil {
+			timerange := *current.Timerange
+			m.Timerange = &timerange
+			continue
+		}
+		if current.Timerange.Start.Before(m.Timerange.Start) {
+			m.Timerange.Start = current.Timerange.Start
+		}
+		if current.Timerange.End.After(m.Timerange.End) {
+			m.Timerange.End = current.Timerange.End
+		}
+		if current.Timerange.Step != m.Timerange.Step {
+			m.Timerange.Step = ""
+		}
+	}
+	return m
+}
+
+// AddCounts adds the series and samples of value to the manifest.
+func (m *Manifest) AddCounts(value prommodel.Value) {
+	matrix, ok := value.(prommodel.Matrix)
+	if !ok {
+		return
+	}
+	m.Series += len(matrix)
+	for _, stream := range matrix {
+		m.Rows += len(stream.Values)
+	}
+}
+
+// Metadata returns the manifest as parquet key value metadata.
+func (m *Manifest) Metadata() (map[string]string, error) {
+	marshaled, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{ParquetKey: string(marshaled)}, nil
+}
+
+// SidecarPath returns the path of the manifest written next to the data file at path.
+func SidecarPath(path string) string {
+	return path + sidecarSuffix
+}
+
+// JSON returns the manifest as indented JSON.
+func (m *Manifest) JSON() ([]byte, error) {
+	marshaled, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(marshaled, '\n'), nil
+}
+
+// WriteSidecar atomically writes the manifest next to the data file at path.
+func (m *Manifest) WriteSidecar(path string, force bool) error {
+	marshaled, err := m.JSON()
+	if err != nil {
+		return err
+	}
+	out, err := output.Create(SidecarPath(path), force)
+	if err != nil {
+		return err
+	}
+	defer out.Abort()
+	if _, err := out.Write(marshaled); err != nil {
+		return err
+	}
+	return out.Commit()
+}
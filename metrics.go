@@ -0,0 +1,218 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/sapcc/promdump/client"
+	"github.com/sapcc/promdump/model"
+	"github.com/sapcc/promdump/query"
+	"github.com/urfave/cli/v2"
+)
+
+type metricsConfig struct {
+	http     client.HTTPConfig
+	output   outputConfig
+	promURLs []query.Target
+	lookup   query.MetricsConfig
+	partial  bool
+	// table prints a summary for terminals instead of writing through the output pipeline
+	table bool
+}
+
+func metricsFlags(ctx *cli.Context) (query.MetricsConfig, error) {
+	cfg := query.MetricsConfig{
+		Matches:     ctx.StringSlice("match"),
+		Concurrency: ctx.Int("concurrency"),
+		NoLabels:    ctx.Bool("no-labels"),
+	}
+	source, err := query.ParseCardinalitySource(ctx.String("cardinality"))
+	if err != nil {
+		return cfg, err
+	}
+	if source == query.CardinalitySeries && cfg.NoLabels {
+		return cfg, fmt.Errorf("--no-labels cannot be combined with --cardinality series")
+	}
+	if source != query.CardinalitySeries && ctx.IsSet("top") {
+		return cfg, fmt.Errorf("--top requires --cardinality series, the tsdb head statistics have no values per label")
+	}
+	cfg.Cardinality, cfg.TopValues = source, ctx.Int("top")
+	cfg.Start, cfg.End = catalogWindow(ctx)
+	for _, name := range ctx.StringSlice("name") {
+		re, err := regexp.Compile("^(?:" + name + ")$")
+		if err != nil {
+			return cfg, fmt.Errorf("invalid --name %q: %w", name, err)
+		}
+		cfg.Names = append(cfg.Names, re)
+	}
+	return cfg, nil
+}
+
+func metrics(ctx context.Context, w io.Writer, cfg metricsConfig) error {
+	httpClient, err := client.MakeHTTPClient(cfg.http)
+	if err != nil {
+		return err
+	}
+	out, err := cfg.output.openCatalog(w, cfg.table)
+	if err != nil {
+		return err
+	}
+	defer out.abort()
+	metrics, err := query.MetricsAcross(ctx, cfg.promURLs, cfg.lookup, &httpClient)
+	if err != nil {
+		return err
+	}
+	if cfg.partial {
+		partial := make([]query.MetricDump, 0)
+		for _, metric := range metrics {
+			if metric.Partial() {
+				partial = append(partial, metric)
+			}
+		}
+		metrics = partial
+	}
+	if out.asText() {
+		return out.write(cfg.summaryTable(metrics), cfg.promURLs)
+	}
+	if model.Layout(cfg.output.layout) == model.LayoutFlat {
+		return out.write(cfg.flatTable(metrics), cfg.promURLs)
+	}
+	// the nested and raw layouts keep the structure of query.MetricDump
+	var write func(w io.Writer) error
+	switch model.Format(cfg.output.format) {
+	case model.FormatJSON:
+		write = func(w io.Writer) error {
+			marshaled, err := json.Marshal(metrics)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(marshaled)
+			return err
+		}
+	case model.FormatNDJSON:
+		write = func(w io.Writer) error {
+			encoder := json.NewEncoder(w)
+			for _, metric := range metrics {
+				if err := encoder.Encode(metric); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	default:
+		return fmt.Errorf("the %s layout of metrics only supports json and ndjson, use the flat layout for %s", cfg.output.layout, cfg.output.format)
+	}
+	return out.commit(write, len(metrics), cfg.promURLs)
+}
+
+func (cfg *metricsConfig) multiple() bool {
+	return len(cfg.promURLs) > 1
+}
+
+// flatTable has one row per metric and label name, or per prometheus, metric
+// and label name for multiple prometheis. Metrics without labels get a
+// single row with an empty label.
+func (cfg *metricsConfig) flatTable(metrics []query.MetricDump) *model.Table {
+	columns := []string{"metric", "type", "unit", "help", "label"}
+	if cfg.multiple() {
+		columns = append([]string{"server"}, columns...)
+	}
+	switch cfg.lookup.Cardinality {
+	case query.CardinalitySeries:
+		columns = append(columns, "series", "values")
+	case query.CardinalityTSDB:
+		columns = append(columns, "series")
+	}
+	table := model.NewTable(append(columns, "conflicts")...)
+	addRows := func(server string, metric query.MetricDump, labels []string, cardinality *query.Cardinality) {
+		row := map[string]interface{}{
+			"metric":    metric.Name,
+			"type":      metric.Type,
+			"unit":      metric.Unit,
+			"help":      metric.Help,
+			"conflicts": strings.Join(metric.Conflicts, ","),
+		}
+		if server != "" {
+			row["server"] = server
+		}
+		values := make(map[string]int64)
+		if cardinality != nil {
+			row["series"] = int64(cardinality.Series)
+			for _, label := range cardinality.Labels {
+				values[label.Name] = int64(label.Values)
+			}
+		}
+		if len(labels) == 0 {
+			table.Add(row)
+			return
+		}
+		for _, label := range labels {
+			labelRow := make(map[string]interface{}, len(row)+2)
+			for key, val := range row {
+				labelRow[key] = val
+			}
+			labelRow["label"] = label
+			if count, ok := values[label]; ok {
+				labelRow["values"] = count
+			}
+			table.Add(labelRow)
+		}
+	}
+	for _, metric := range metrics {
+		if !cfg.multiple() {
+			addRows("", metric, metric.Labels, metric.Cardinality)
+			continue
+		}
+		for _, server := range metric.Servers {
+			addRows(server.Server, metric, server.Labels, server.Cardinality)
+		}
+	}
+	return table
+}
+
+// summaryTable has one row per metric with its labels joined.
+func (cfg *metricsConfig) summaryTable(metrics []query.MetricDump) *model.Table {
+	columns := []string{"metric", "type", "unit"}
+	if cfg.lookup.Cardinality != query.CardinalityNone && !cfg.multiple() {
+		columns = append(columns, "series")
+	}
+	columns = append(columns, "labels")
+	if cfg.multiple() {
+		columns = append(columns, "missing from", "partial labels")
+	}
+	table := model.NewTable(append(columns, "conflicts", "help")...)
+	for _, metric := range metrics {
+		row := map[string]interface{}{
+			"metric":         metric.Name,
+			"type":           metric.Type,
+			"unit":           metric.Unit,
+			"labels":         strings.Join(metric.Labels, ","),
+			"missing from":   strings.Join(metric.MissingFrom, ","),
+			"partial labels": strings.Join(metric.PartialLabels, ","),
+			"conflicts":      strings.Join(metric.Conflicts, ","),
+			"help":           metric.Help,
+		}
+		if metric.Cardinality != nil {
+			row["series"] = int64(metric.Cardinality.Series)
+		}
+		table.Add(row)
+	}
+	return table
+}
@@ -14,11 +14,17 @@ limitations under the License.
 package model
 
 import (
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
+	"sort"
+	"strconv"
 
 	"github.com/prometheus/common/model"
-	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go-source/writerfile"
+	"github.com/xitongsys/parquet-go/parquet"
 	"github.com/xitongsys/parquet-go/writer"
 )
 
@@ -30,26 +36,50 @@ const (
 	LayoutNested  = "nested"
 	LayoutFlat    = "flat"
 	FormatJSON    = "json"
+	FormatNDJSON  = "ndjson"
+	FormatCSV     = "csv"
 	FormatParquet = "parquet"
 )
 
+// Marshaler writes its data in one of the formats. Everything is written
+// while marshaling, so the output is never held in memory as a whole.
 type Marshaler interface {
-	AsJSON() ([]byte, error)
-	AsParquet() ([]byte, error)
+	WriteJSON(w io.Writer) error
+	WriteNDJSON(w io.Writer) error
+	WriteCSV(w io.Writer) error
+	// WriteParquet stores metadata as key value metadata in the file footer.
+	WriteParquet(w io.Writer, metadata map[string]string) error
 }
 
 func MarshalSlice(values []model.Value, layout Layout, format Format) ([]byte, error) {
+	buf := bytes.Buffer{}
+	if err := WriteSlice(&buf, values, layout, format, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteSlice is MarshalSlice writing into w with metadata embedded into formats that support it.
+func WriteSlice(w io.Writer, values []model.Value, layout Layout, format Format, metadata map[string]string) error {
 	marshaler, err := AsMarshalerSlice(values, layout)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	return write(w, marshaler, format, metadata)
+}
+
+func write(w io.Writer, marshaler Marshaler, format Format, metadata map[string]string) error {
 	switch format {
 	case FormatJSON:
-		return marshaler.AsJSON()
+		return marshaler.WriteJSON(w)
+	case FormatNDJSON:
+		return marshaler.WriteNDJSON(w)
+	case FormatCSV:
+		return marshaler.WriteCSV(w)
 	case FormatParquet:
-		return marshaler.AsParquet()
+		return marshaler.WriteParquet(w, metadata)
 	}
-	return nil, fmt.Errorf("unknown format: %s", layout)
+	return fmt.Errorf("unknown format: %s", format)
 }
 
 func Marshal(value model.Value, layout Layout, format Format) ([]byte, error) {
@@ -97,24 +127,49 @@ type WrappedValue struct {
 	value model.Value
 }
 
-func (val *WrappedValue) AsJSON() ([]byte, error) {
-	return json.Marshal(val.value)
+func (val *WrappedValue) WriteJSON(w io.Writer) error {
+	return writeValue(w, val.value)
+}
+
+func (val *WrappedValue) WriteNDJSON(w io.Writer) error {
+	if err := writeValue(w, val.value); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
 }
 
-func (val *WrappedValue) AsParquet() ([]byte, error) {
-	return nil, fmt.Errorf("serializing raw prometheus values to parquet is not supported")
+func (val *WrappedValue) WriteCSV(w io.Writer) error {
+	return fmt.Errorf("serializing raw prometheus values to csv is not supported")
+}
+
+func (val *WrappedValue) WriteParquet(w io.Writer, metadata map[string]string) error {
+	return fmt.Errorf("serializing raw prometheus values to parquet is not supported")
 }
 
 type WrappedValueSlice struct {
 	values []model.Value
 }
 
-func (wvs *WrappedValueSlice) AsJSON() ([]byte, error) {
-	return json.Marshal(wvs.values)
+func (wvs *WrappedValueSlice) WriteJSON(w io.Writer) error {
+	return writeJSONArrayFunc(w, len(wvs.values), func(i int) error { return writeValue(w, wvs.values[i]) })
+}
+
+func (wvs *WrappedValueSlice) WriteNDJSON(w io.Writer) error {
+	for _, value := range wvs.values {
+		if err := (&WrappedValue{value: value}).WriteNDJSON(w); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (wvs *WrappedValueSlice) AsParquet() ([]byte, error) {
-	return nil, fmt.Errorf("serializing raw prometheus values to parquet is not supported")
+func (wvs *WrappedValueSlice) WriteCSV(w io.Writer) error {
+	return fmt.Errorf("serializing raw prometheus values to csv is not supported")
+}
+
+func (wvs *WrappedValueSlice) WriteParquet(w io.Writer, metadata map[string]string) error {
+	return fmt.Errorf("serializing raw prometheus values to parquet is not supported")
 }
 
 type SampleDump struct {
@@ -148,27 +203,53 @@ func ValueToSampleDumps(value model.Value) (SampleDumps, error) {
 	return dumps, nil
 }
 
-func (dumps *SampleDumps) AsJSON() ([]byte, error) {
-	return json.Marshal(dumps)
+func (dumps *SampleDumps) WriteJSON(w io.Writer) error {
+	return writeJSONArray(w, len(*dumps), func(i int) interface{} { return (*dumps)[i] })
+}
+
+func (dumps *SampleDumps) WriteNDJSON(w io.Writer) error {
+	return writeNDJSON(w, len(*dumps), func(i int) interface{} { return (*dumps)[i] })
 }
 
-func (dumps *SampleDumps) AsParquet() ([]byte, error) {
-	buf := buffer.NewBufferFile()
-	writer, err := writer.NewParquetWriter(buf, new(SampleDump), 4)
+// WriteCSV writes the label set of each sample as JSON object into the labels column.
+func (dumps *SampleDumps) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"metric", "labels", "timestamp", "value"})
 	if err != nil {
-		return nil, err
+		return err
 	}
 	for _, dump := range *dumps {
-		err = writer.Write(dump)
+		labels, err := json.Marshal(dump.Labels)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		err = writer.Write([]string{
+			dump.Metric,
+			string(labels),
+			strconv.FormatInt(dump.Timestamp, 10),
+			strconv.FormatFloat(dump.Value, 'g', -1, 64),
+		})
+		if err != nil {
+			return err
 		}
 	}
-	err = writer.WriteStop()
+	writer.Flush()
+	return writer.Error()
+}
+
+func (dumps *SampleDumps) WriteParquet(w io.Writer, metadata map[string]string) error {
+	writer, err := writer.NewParquetWriter(writerfile.NewWriterFile(w), new(SampleDump), 4)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return buf.Bytes(), nil
+	for _, dump := range *dumps {
+		err = writer.Write(dump)
+		if err != nil {
+			return err
+		}
+	}
+	setParquetMetadata(writer, metadata)
+	return writer.WriteStop()
 }
 
 type FlattenedSampleDump struct {
@@ -196,38 +277,197 @@ func FlattenDumps(dumps SampleDumps) FlattenedSampleDumps {
 	return flattened
 }
 
-func (flattened *FlattenedSampleDumps) AsJSON() ([]byte, error) {
-	unpacked := make([]map[string]interface{}, 0)
+func (flattened *FlattenedSampleDumps) WriteJSON(w io.Writer) error {
+	return writeJSONArray(w, len(*flattened), func(i int) interface{} { return (*flattened)[i].Data })
+}
+
+func (flattened *FlattenedSampleDumps) WriteNDJSON(w io.Writer) error {
+	return writeNDJSON(w, len(*flattened), func(i int) interface{} { return (*flattened)[i].Data })
+}
+
+// WriteCSV uses the union of all keys as columns, missing labels are left empty.
+func (flattened *FlattenedSampleDumps) WriteCSV(w io.Writer) error {
+	return flattened.writeCSV(w, flattened.columns())
+}
+
+func (flattened *FlattenedSampleDumps) writeCSV(w io.Writer, template map[string]interface{}) error {
+	columns := make([]string, 0, len(template))
+	labels := make(map[string]struct{})
+	for _, key := range []string{"metric", "timestamp", "value"} {
+		if _, ok := template[key]; ok {
+			columns = append(columns, key)
+		}
+	}
+	for key := range template {
+		if key != "metric" && key != "timestamp" && key != "value" {
+			labels[key] = struct{}{}
+		}
+	}
+	columns = append(columns, sortedKeys(labels)...)
+	writer := csv.NewWriter(w)
+	err := writer.Write(columns)
+	if err != nil {
+		return err
+	}
+	row := make([]string, len(columns))
 	for _, single := range *flattened {
-		unpacked = append(unpacked, single.Data)
+		for i, column := range columns {
+			row[i] = csvField(single.Data[column])
+		}
+		err = writer.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func (flattened *FlattenedSampleDumps) WriteParquet(w io.Writer, metadata map[string]string) error {
+	return flattened.writeParquet(w, flattened.columns(), metadata)
+}
+
+// columns returns the union of all keys with an example value each, since
+// samples of different series may carry different labels.
+func (flattened *FlattenedSampleDumps) columns() map[string]interface{} {
+	columns := map[string]interface{}{"metric": "", "timestamp": int64(0), "value": float64(0)}
+	for _, dump := range *flattened {
+		for key, val := range dump.Data {
+			if _, ok := columns[key]; !ok {
+				columns[key] = val
+			}
+		}
 	}
-	return json.Marshal(unpacked)
+	return columns
 }
 
-func (flattened *FlattenedSampleDumps) AsParquet() ([]byte, error) {
-	buf := buffer.NewBufferFile()
-	first := (*flattened)[0]
-	schema, err := ParquetSchemaFor(first.Data)
+func (flattened *FlattenedSampleDumps) writeParquet(w io.Writer, columns map[string]interface{}, metadata map[string]string) error {
+	if len(*flattened) == 0 {
+		return fmt.Errorf("no samples to write to parquet")
+	}
+	schema, err := ParquetSchemaFor(columns)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create parquet schema: %w", err)
+		return fmt.Errorf("failed to create parquet schema: %w", err)
 	}
-	writer, err := writer.NewJSONWriter(schema, buf, 1)
+	writer, err := writer.NewJSONWriter(schema, writerfile.NewWriterFile(w), 1)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize parquet writer: %w", err)
+		return fmt.Errorf("failed to initialize parquet writer: %w", err)
 	}
 	for _, dump := range *flattened {
 		marshaled, err := json.Marshal(dump.Data)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal json: %w", err)
+			return fmt.Errorf("failed to marshal json: %w", err)
 		}
 		err = writer.Write(marshaled)
 		if err != nil {
-			return nil, fmt.Errorf("failed to write parquet entry: %w", err)
+			return fmt.Errorf("failed to write parquet entry: %w", err)
 		}
 	}
+	setParquetMetadata(&writer.ParquetWriter, metadata)
 	err = writer.WriteStop()
 	if err != nil {
-		return nil, fmt.Errorf("failed to write parquet footer: %w", err)
+		return fmt.Errorf("failed to write parquet footer: %w", err)
+	}
+	return nil
+}
+
+// writeJSONArray writes the same as json.Marshal of a slice with n elements,
+// but marshals one element at a time.
+func writeJSONArray(w io.Writer, n int, elem func(i int) interface{}) error {
+	return writeJSONArrayFunc(w, n, func(i int) error {
+		marshaled, err := json.Marshal(elem(i))
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(marshaled)
+		return err
+	})
+}
+
+func writeJSONArrayFunc(w io.Writer, n int, writeElem func(i int) error) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if err := writeElem(i); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]")
+	return err
+}
+
+// writeValue writes value like json.Marshal, matrices one series at a time.
+func writeValue(w io.Writer, value model.Value) error {
+	if matrix, ok := value.(model.Matrix); ok && matrix != nil {
+		return writeJSONArray(w, len(matrix), func(i int) interface{} { return matrix[i] })
+	}
+	marshaled, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(marshaled)
+	return err
+}
+
+func writeNDJSON(w io.Writer, n int, line func(i int) interface{}) error {
+	encoder := json.NewEncoder(w)
+	for i := 0; i < n; i++ {
+		if err := encoder.Encode(line(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func csvField(val interface{}) string {
+	switch typed := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case model.LabelValue:
+		return string(typed)
+	case int64:
+		return strconv.FormatInt(typed, 10)
+	case float64:
+		return strconv.FormatFloat(typed, 'g', -1, 64)
+	case int:
+		return strconv.Itoa(typed)
+	case bool:
+		return strconv.FormatBool(typed)
+	case map[string]string:
+		// label sets are written as json object like in the nested layout
+		marshaled, err := json.Marshal(typed)
+		if err == nil {
+			return string(marshaled)
+		}
+	}
+	return fmt.Sprint(val)
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func setParquetMetadata(pw *writer.ParquetWriter, metadata map[string]string) {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := metadata[key]
+		pw.Footer.KeyValueMetadata = append(pw.Footer.KeyValueMetadata, &parquet.KeyValue{Key: key, Value: &value})
 	}
-	return buf.Bytes(), nil
 }
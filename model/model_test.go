@@ -0,0 +1,116 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+func testMatrix(series, samples int) model.Matrix {
+	matrix := make(model.Matrix, 0, series)
+	for i := 0; i < series; i++ {
+		stream := &model.SampleStream{Metric: model.Metric{
+			model.MetricNameLabel: "up",
+			"instance":            model.LabelValue(fmt.Sprintf("node-%d:9100", i)),
+			"job":                 "node<&>",
+		}}
+		for j := 0; j < samples; j++ {
+			stream.Values = append(stream.Values, model.SamplePair{Timestamp: model.Time(1696154400000 + int64(j)*60000), Value: model.SampleValue(float64(i) + 0.5)})
+		}
+		matrix = append(matrix, stream)
+	}
+	return matrix
+}
+
+// countingWriter records the size of every write.
+type countingWriter struct {
+	bytes.Buffer
+	writes []int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, len(p))
+	return w.Buffer.Write(p)
+}
+
+func TestWriteSliceStreams(t *testing.T) {
+	for _, layout := range []Layout{LayoutRaw, LayoutNested, LayoutFlat} {
+		for _, format := range []Format{FormatJSON, FormatNDJSON, FormatCSV, FormatParquet} {
+			if layout == LayoutRaw && (format == FormatCSV || format == FormatParquet) {
+				continue
+			}
+			w := &countingWriter{}
+			values := []model.Value{testMatrix(50, 20), testMatrix(1, 1)}
+			if err := WriteSlice(w, values, layout, format, nil); err != nil {
+				t.Fatalf("%s %s: %s", layout, format, err)
+			}
+			largest := 0
+			for _, size := range w.writes {
+				if size > largest {
+					largest = size
+				}
+			}
+			if largest*2 > w.Len() {
+				t.Errorf("%s %s: a single write of %d bytes out of %d", layout, format, largest, w.Len())
+			}
+		}
+	}
+}
+
+func TestWriteJSONMatchesMarshal(t *testing.T) {
+	values := []model.Value{testMatrix(3, 2), testMatrix(2, 1)}
+	nested, err := AsMarshalerSlice(values, LayoutNested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flat, err := AsMarshalerSlice(values, LayoutFlat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unpacked := make([]map[string]interface{}, 0)
+	for _, dump := range *flat.(*FlattenedSampleDumps) {
+		unpacked = append(unpacked, dump.Data)
+	}
+	table := NewTable("metric", "help")
+	table.Add(map[string]interface{}{"metric": "up", "help": "<up>", "ignored": true})
+	table.Add(map[string]interface{}{"metric": "down", "help": nil})
+	cases := []struct {
+		marshaler Marshaler
+		expected  interface{}
+	}{
+		{&WrappedValueSlice{values: values}, values},
+		{nested, nested},
+		{flat, unpacked},
+		{&FlattenedSampleDumps{}, []interface{}{}},
+		{table, []map[string]interface{}{{"metric": "up", "help": "<up>"}, {"metric": "down"}}},
+	}
+	for _, c := range cases {
+		expected, err := json.Marshal(c.expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := bytes.Buffer{}
+		if err := c.marshaler.WriteJSON(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != string(expected) {
+			t.Errorf("%T: wrote %s, want %s", c.marshaler, buf.String(), expected)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// PartitionMetric partitions by metric name, every other key except PartitionDate is a label name.
+	PartitionMetric = "metric"
+	// PartitionDate partitions by the UTC date of the sample timestamp.
+	PartitionDate = "date"
+)
+
+// hiveDefaultPartition is used by hive, spark and duckdb for missing partition values.
+const hiveDefaultPartition = "__HIVE_DEFAULT_PARTITION__"
+
+type PartitionConfig struct {
+	Keys []string
+	// MaxRowsPerFile splits partitions into multiple files, 0 means unlimited.
+	MaxRowsPerFile int
+	Format         Format
+	Metadata       map[string]string
+}
+
+// Part is a single file of a partitioned dump.
+type Part struct {
+	// Path is relative to the dump directory, e.g. metric=up/date=2023-10-01/part-0.parquet.
+	Path string
+	Rows int
+	// Write marshals the rows of the part into w.
+	Write func(w io.Writer) error
+}
+
+type partition struct {
+	dir  string
+	rows FlattenedSampleDumps
+}
+
+// MarshalPartitioned splits the flattened samples of values into hive style
+// partitions and calls emit for every file. Partition columns are not
+// repeated inside the files and all files share the same columns.
+func MarshalPartitioned(values []model.Value, cfg PartitionConfig, emit func(Part) error) error {
+	if len(cfg.Keys) == 0 {
+		return fmt.Errorf("no partition keys given")
+	}
+	if cfg.MaxRowsPerFile < 0 {
+		return fmt.Errorf("max rows per file must not be negative")
+	}
+	marshaler, err := AsMarshalerSlice(values, LayoutFlat)
+	if err != nil {
+		return err
+	}
+	flattened := *marshaler.(*FlattenedSampleDumps)
+	columns := flattened.columns()
+	for _, key := range cfg.Keys {
+		if key == "timestamp" || key == "value" {
+			return fmt.Errorf("cannot partition by %s", key)
+		}
+		delete(columns, key)
+	}
+
+	partitions := make(map[string]*partition)
+	for _, row := range flattened {
+		segments := make([]string, 0, len(cfg.Keys))
+		for _, key := range cfg.Keys {
+			segments = append(segments, key+"="+escapePartitionValue(partitionValue(row, key)))
+			delete(row.Data, key)
+		}
+		dir := path.Join(segments...)
+		current, ok := partitions[dir]
+		if !ok {
+			current = &partition{dir: dir}
+			partitions[dir] = current
+		}
+		current.rows = append(current.rows, row)
+	}
+
+	dirs := make([]string, 0, len(partitions))
+	for dir := range partitions {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		rows := partitions[dir].rows
+		for i := 0; len(rows) > 0; i++ {
+			n := len(rows)
+			if cfg.MaxRowsPerFile > 0 && n > cfg.MaxRowsPerFile {
+				n = cfg.MaxRowsPerFile
+			}
+			chunk := rows[:n]
+			rows = rows[n:]
+			write := func(w io.Writer) error {
+				return chunk.writeColumns(w, columns, cfg.Format, cfg.Metadata)
+			}
+			err := emit(Part{Path: path.Join(dir, fmt.Sprintf("part-%d.%s", i, cfg.Format)), Rows: n, Write: write})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (flattened FlattenedSampleDumps) writeColumns(w io.Writer, columns map[string]interface{}, format Format, metadata map[string]string) error {
+	switch format {
+	case FormatCSV:
+		return flattened.writeCSV(w, columns)
+	case FormatParquet:
+		return flattened.writeParquet(w, columns, metadata)
+	}
+	return write(w, &flattened, format, metadata)
+}
+
+func partitionValue(row FlattenedSampleDump, key string) string {
+	if key == PartitionDate {
+		if _, ok := row.Data[PartitionDate]; !ok {
+			timestamp, _ := row.Data["timestamp"].(int64)
+			return time.UnixMilli(timestamp).UTC().Format("2006-01-02")
+		}
+	}
+	return csvField(row.Data[key])
+}
+
+// escapePartitionValue escapes like hive does, so values round trip through
+// readers that unescape partition directories.
+func escapePartitionValue(value string) string {
+	if value == "" {
+		return hiveDefaultPartition
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// UnmarshalPart reads a part file of a partitioned dump, the partition
+// columns are restored from the directories of its slash separated path
+// relative to the dump directory.
+func UnmarshalPart(path string, data []byte, format Format) (SampleDumps, error) {
+	columns, err := partitionColumns(path)
+	if err != nil {
+		return nil, err
+	}
+	flattened := FlattenedSampleDumps{}
+	if err := unmarshal(&flattened, data, format); err != nil {
+		return nil, err
+	}
+	for _, row := range flattened {
+		for key, value := range columns {
+			if key == PartitionDate {
+				// the date is derived from the timestamp, unless there is a date label
+				timestamp, err := toInt64(row.Data["timestamp"])
+				if err == nil && time.UnixMilli(timestamp).UTC().Format("2006-01-02") == value {
+					continue
+				}
+			}
+			row.Data[key] = value
+		}
+	}
+	return UnflattenDumps(flattened)
+}
+
+// partitionColumns parses the key=value directories of path, missing values are left out.
+func partitionColumns(path string) (map[string]string, error) {
+	segments := strings.Split(path, "/")
+	columns := make(map[string]string, len(segments)-1)
+	for _, segment := range segments[:len(segments)-1] {
+		key, value, ok := strings.Cut(segment, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s is not a hive style partition", path)
+		}
+		if value == hiveDefaultPartition {
+			continue
+		}
+		unescaped, err := unescapePartitionValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		columns[key] = unescaped
+	}
+	return columns, nil
+}
+
+func unescapePartitionValue(value string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '%' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return "", fmt.Errorf("invalid escape in partition value %s", value)
+		}
+		c, err := strconv.ParseUint(value[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in partition value %s", value)
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String(), nil
+}
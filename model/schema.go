@@ -15,14 +15,28 @@ package model
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/prometheus/common/model"
 )
 
 func ParquetSchemaFor(data map[string]interface{}) (string, error) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	// a stable column order keeps the schemas of multiple files compatible
+	sort.Strings(keys)
 	fields := make([]string, 0)
-	for key, val := range data {
+	for _, key := range keys {
+		val := data[key]
+		if _, ok := val.(map[string]string); ok {
+			fields = append(fields, fmt.Sprintf("{\"Tag\": \"name=%s, type=MAP, convertedtype=MAP, repetitiontype=OPTIONAL\", \"Fields\": [%s, %s]}", key,
+				"{\"Tag\": \"name=key, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED\"}",
+				"{\"Tag\": \"name=value, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL\"}"))
+			continue
+		}
 		parquetType, err := parquetTypeFor(val)
 		if err != nil {
 			return "", err
@@ -44,6 +58,8 @@ func parquetTypeFor(val any) (string, error) {
 		return "type=INT64, repetitiontype=OPTIONAL", nil // encoding=DELTA_BINARY_PACKED
 	case float64:
 		return "type=DOUBLE, repetitiontype=OPTIONAL", nil
+	case bool:
+		return "type=BOOLEAN, repetitiontype=OPTIONAL", nil
 	}
 	return "", fmt.Errorf("unknown type %T for parquet schema generation", val)
 }
@@ -0,0 +1,151 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/xitongsys/parquet-go-source/writerfile"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Table holds rows of catalog data like metrics or targets instead of
+// samples. Columns gives the order of the csv and text output, values
+// missing from a row are left empty.
+type Table struct {
+	Columns []string
+	Rows    []map[string]interface{}
+}
+
+// NewTable returns an empty table with the given columns.
+func NewTable(columns ...string) *Table {
+	return &Table{Columns: columns, Rows: make([]map[string]interface{}, 0)}
+}
+
+// Add appends a row, keys not in the columns are ignored.
+func (t *Table) Add(row map[string]interface{}) {
+	t.Rows = append(t.Rows, row)
+}
+
+// WriteTable serializes t in format into w with metadata embedded into parquet.
+func WriteTable(w io.Writer, t *Table, format Format, metadata map[string]string) error {
+	return write(w, t, format, metadata)
+}
+
+func (t *Table) WriteJSON(w io.Writer) error {
+	return writeJSONArray(w, len(t.Rows), func(i int) interface{} { return t.project(t.Rows[i]) })
+}
+
+func (t *Table) WriteNDJSON(w io.Writer) error {
+	return writeNDJSON(w, len(t.Rows), func(i int) interface{} { return t.project(t.Rows[i]) })
+}
+
+func (t *Table) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(t.Columns); err != nil {
+		return err
+	}
+	if err := t.forEachRecord(func(record []string) error { return writer.Write(record) }); err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteParquet derives the type of each column from its first value, columns without values are strings.
+func (t *Table) WriteParquet(w io.Writer, metadata map[string]string) error {
+	columns := make(map[string]interface{}, len(t.Columns))
+	for _, column := range t.Columns {
+		columns[column] = ""
+		for _, row := range t.Rows {
+			if val, ok := row[column]; ok && val != nil {
+				columns[column] = val
+				break
+			}
+		}
+	}
+	schema, err := ParquetSchemaFor(columns)
+	if err != nil {
+		return fmt.Errorf("failed to create parquet schema: %w", err)
+	}
+	writer, err := writer.NewJSONWriter(schema, writerfile.NewWriterFile(w), 1)
+	if err != nil {
+		return fmt.Errorf("failed to initialize parquet writer: %w", err)
+	}
+	for _, row := range t.Rows {
+		marshaled, err := json.Marshal(t.project(row))
+		if err != nil {
+			return fmt.Errorf("failed to marshal json: %w", err)
+		}
+		if err := writer.Write(marshaled); err != nil {
+			return fmt.Errorf("failed to write parquet entry: %w", err)
+		}
+	}
+	setParquetMetadata(&writer.ParquetWriter, metadata)
+	if err := writer.WriteStop(); err != nil {
+		return fmt.Errorf("failed to write parquet footer: %w", err)
+	}
+	return nil
+}
+
+// WriteText writes t as aligned table with upper case headers for terminals.
+func (t *Table) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	headers := make([]string, len(t.Columns))
+	for i, column := range t.Columns {
+		headers[i] = strings.ToUpper(column)
+	}
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	err := t.forEachRecord(func(record []string) error {
+		for i := range record {
+			// tabs and newlines in help texts or errors would break the alignment
+			record[i] = strings.NewReplacer("\t", " ", "\n", " ").Replace(record[i])
+		}
+		_, err := fmt.Fprintln(tw, strings.Join(record, "\t"))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Flush()
+}
+
+// project restricts row to the columns, nil values are left out.
+func (t *Table) project(row map[string]interface{}) map[string]interface{} {
+	data := make(map[string]interface{}, len(t.Columns))
+	for _, column := range t.Columns {
+		if val, ok := row[column]; ok && val != nil {
+			data[column] = val
+		}
+	}
+	return data
+}
+
+func (t *Table) forEachRecord(fn func(record []string) error) error {
+	record := make([]string, len(t.Columns))
+	for _, row := range t.Rows {
+		for i, column := range t.Columns {
+			record[i] = csvField(row[column])
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,547 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+
+	"github.com/prometheus/common/model"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// Unmarshaler is the counterpart of Marshaler.
+type Unmarshaler interface {
+	FromJSON(data []byte) error
+	FromNDJSON(data []byte) error
+	FromCSV(data []byte) error
+	FromParquet(data []byte) error
+	// AsSampleDumps returns everything read so far.
+	AsSampleDumps() (SampleDumps, error)
+}
+
+var parquetMagic = []byte("PAR1")
+
+// DetectFormat guesses the format of uncompressed data from its first bytes.
+func DetectFormat(data []byte) (Format, error) {
+	if bytes.HasPrefix(data, parquetMagic) {
+		return FormatParquet, nil
+	}
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	if len(trimmed) == 0 {
+		return "", fmt.Errorf("no data")
+	}
+	switch trimmed[0] {
+	case '[':
+		// raw ndjson has one array per line
+		if json.Valid(trimmed) {
+			return FormatJSON, nil
+		}
+		return FormatNDJSON, nil
+	case '{':
+		return FormatNDJSON, nil
+	}
+	return FormatCSV, nil
+}
+
+// DetectLayout guesses the layout of data in format from its first row.
+func DetectLayout(data []byte, format Format) (Layout, error) {
+	switch format {
+	case FormatJSON:
+		var rows []json.RawMessage
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return "", fmt.Errorf("failed to parse json: %w", err)
+		}
+		if len(rows) == 0 {
+			return LayoutFlat, nil
+		}
+		return detectJSONLayout(rows[0])
+	case FormatNDJSON:
+		line, _, _ := bytes.Cut(bytes.TrimLeft(data, " \t\r\n"), []byte("\n"))
+		return detectJSONLayout(line)
+	case FormatCSV:
+		header, err := csv.NewReader(bytes.NewReader(data)).Read()
+		if err != nil {
+			return "", fmt.Errorf("failed to parse csv header: %w", err)
+		}
+		for _, column := range header {
+			if column == "labels" {
+				return LayoutNested, nil
+			}
+		}
+		return LayoutFlat, nil
+	case FormatParquet:
+		pr, err := reader.NewParquetReader(buffer.NewBufferFileFromBytes(data), nil, 1)
+		if err != nil {
+			return "", fmt.Errorf("failed to read parquet footer: %w", err)
+		}
+		defer pr.ReadStop()
+		elements := pr.SchemaHandler.SchemaElements
+		for i := 1; i < len(elements); i = skipParquetElement(elements, i) {
+			if pr.SchemaHandler.Infos[i].ExName == "labels" && elements[i].GetNumChildren() > 0 {
+				return LayoutNested, nil
+			}
+		}
+		return LayoutFlat, nil
+	}
+	return "", fmt.Errorf("unknown format: %s", format)
+}
+
+func detectJSONLayout(row json.RawMessage) (Layout, error) {
+	row = bytes.TrimLeft(row, " \t\r\n")
+	if len(row) > 0 && row[0] == '[' {
+		return LayoutRaw, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(row, &fields); err != nil {
+		return "", fmt.Errorf("failed to parse json: %w", err)
+	}
+	_, hasValues := fields["values"]
+	_, hasTimestamp := fields["timestamp"]
+	if hasValues && !hasTimestamp {
+		return LayoutRaw, nil
+	}
+	if labels, ok := fields["labels"]; ok && bytes.HasPrefix(bytes.TrimLeft(labels, " \t\r\n"), []byte("{")) {
+		return LayoutNested, nil
+	}
+	return LayoutFlat, nil
+}
+
+// UnmarshalSampleDumps reads data written by MarshalSlice with layout and format.
+func UnmarshalSampleDumps(data []byte, layout Layout, format Format) (SampleDumps, error) {
+	var unmarshaler Unmarshaler
+	switch layout {
+	case LayoutRaw:
+		unmarshaler = &WrappedValueSlice{}
+	case LayoutNested:
+		unmarshaler = &SampleDumps{}
+	case LayoutFlat:
+		unmarshaler = &FlattenedSampleDumps{}
+	default:
+		return nil, fmt.Errorf("unknown layout: %s", layout)
+	}
+	if err := unmarshal(unmarshaler, data, format); err != nil {
+		return nil, err
+	}
+	return unmarshaler.AsSampleDumps()
+}
+
+// UnmarshalRows reads tables like catalogs written by WriteTable, json
+// objects in the nested layout are read as rows as well.
+func UnmarshalRows(data []byte, format Format) ([]map[string]interface{}, error) {
+	flattened := FlattenedSampleDumps{}
+	if err := unmarshal(&flattened, data, format); err != nil {
+		return nil, err
+	}
+	rows := make([]map[string]interface{}, 0, len(flattened))
+	for _, row := range flattened {
+		rows = append(rows, row.Data)
+	}
+	return rows, nil
+}
+
+func unmarshal(unmarshaler Unmarshaler, data []byte, format Format) error {
+	switch format {
+	case FormatJSON:
+		return unmarshaler.FromJSON(data)
+	case FormatNDJSON:
+		return unmarshaler.FromNDJSON(data)
+	case FormatCSV:
+		return unmarshaler.FromCSV(data)
+	case FormatParquet:
+		return unmarshaler.FromParquet(data)
+	}
+	return fmt.Errorf("unknown format: %s", format)
+}
+
+// AsMatrix groups the samples by metric name and labels into series, in the
+// order the series first appear.
+func (dumps *SampleDumps) AsMatrix() model.Matrix {
+	matrix := make(model.Matrix, 0)
+	index := make(map[model.Fingerprint]int)
+	for _, dump := range *dumps {
+		metric := make(model.Metric, len(dump.Labels)+1)
+		for name, value := range dump.Labels {
+			metric[name] = value
+		}
+		if dump.Metric != "" {
+			metric[model.MetricNameLabel] = model.LabelValue(dump.Metric)
+		}
+		fingerprint := metric.Fingerprint()
+		i, ok := index[fingerprint]
+		if !ok {
+			i = len(matrix)
+			index[fingerprint] = i
+			matrix = append(matrix, &model.SampleStream{Metric: metric})
+		}
+		matrix[i].Values = append(matrix[i].Values, model.SamplePair{
+			Timestamp: model.Time(dump.Timestamp),
+			Value:     model.SampleValue(dump.Value),
+		})
+	}
+	return matrix
+}
+
+func (wvs *WrappedValueSlice) FromJSON(data []byte) error {
+	var rows []json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return fmt.Errorf("failed to parse json: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	if layout, err := detectJSONLayout(rows[0]); err == nil && layout == LayoutRaw && bytes.HasPrefix(bytes.TrimLeft(rows[0], " \t\r\n"), []byte("{")) {
+		// a single value is not wrapped into a slice
+		return wvs.appendMatrix(data)
+	}
+	for _, row := range rows {
+		if err := wvs.appendMatrix(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (wvs *WrappedValueSlice) FromNDJSON(data []byte) error {
+	return forEachLine(data, wvs.appendMatrix)
+}
+
+func (wvs *WrappedValueSlice) appendMatrix(data []byte) error {
+	var matrix model.Matrix
+	if err := json.Unmarshal(data, &matrix); err != nil {
+		return fmt.Errorf("failed to parse prometheus matrix: %w", err)
+	}
+	wvs.values = append(wvs.values, matrix)
+	return nil
+}
+
+func (wvs *WrappedValueSlice) FromCSV(data []byte) error {
+	return fmt.Errorf("reading raw prometheus values from csv is not supported")
+}
+
+func (wvs *WrappedValueSlice) FromParquet(data []byte) error {
+	return fmt.Errorf("reading raw prometheus values from parquet is not supported")
+}
+
+func (wvs *WrappedValueSlice) AsSampleDumps() (SampleDumps, error) {
+	dumps := make(SampleDumps, 0)
+	for _, value := range wvs.values {
+		current, err := ValueToSampleDumps(value)
+		if err != nil {
+			return nil, err
+		}
+		dumps = append(dumps, current...)
+	}
+	return dumps, nil
+}
+
+func (dumps *SampleDumps) FromJSON(data []byte) error {
+	var read SampleDumps
+	if err := json.Unmarshal(data, &read); err != nil {
+		return fmt.Errorf("failed to parse json: %w", err)
+	}
+	*dumps = append(*dumps, read...)
+	return nil
+}
+
+func (dumps *SampleDumps) FromNDJSON(data []byte) error {
+	return forEachLine(data, func(line []byte) error {
+		var dump SampleDump
+		if err := json.Unmarshal(line, &dump); err != nil {
+			return fmt.Errorf("failed to parse json: %w", err)
+		}
+		*dumps = append(*dumps, dump)
+		return nil
+	})
+}
+
+// FromCSV expects the columns written by WriteCSV.
+func (dumps *SampleDumps) FromCSV(data []byte) error {
+	return forEachCSVRecord(data, func(record map[string]string) error {
+		dump := SampleDump{Metric: record["metric"]}
+		if err := json.Unmarshal([]byte(record["labels"]), &dump.Labels); err != nil {
+			return fmt.Errorf("failed to parse labels: %w", err)
+		}
+		var err error
+		dump.Timestamp, err = toInt64(record["timestamp"])
+		if err != nil {
+			return err
+		}
+		dump.Value, err = toFloat64(record["value"])
+		if err != nil {
+			return err
+		}
+		*dumps = append(*dumps, dump)
+		return nil
+	})
+}
+
+func (dumps *SampleDumps) FromParquet(data []byte) error {
+	rows, err := readParquetRows(data)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		dump, err := sampleDumpFromRow(row, false)
+		if err != nil {
+			return err
+		}
+		*dumps = append(*dumps, dump)
+	}
+	return nil
+}
+
+func (dumps *SampleDumps) AsSampleDumps() (SampleDumps, error) {
+	return *dumps, nil
+}
+
+func (flattened *FlattenedSampleDumps) FromJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var rows []map[string]interface{}
+	if err := decoder.Decode(&rows); err != nil {
+		return fmt.Errorf("failed to parse json: %w", err)
+	}
+	for _, row := range rows {
+		*flattened = append(*flattened, FlattenedSampleDump{Data: row})
+	}
+	return nil
+}
+
+func (flattened *FlattenedSampleDumps) FromNDJSON(data []byte) error {
+	return forEachLine(data, func(line []byte) error {
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		var row map[string]interface{}
+		if err := decoder.Decode(&row); err != nil {
+			return fmt.Errorf("failed to parse json: %w", err)
+		}
+		*flattened = append(*flattened, FlattenedSampleDump{Data: row})
+		return nil
+	})
+}
+
+// FromCSV treats empty fields as missing labels.
+func (flattened *FlattenedSampleDumps) FromCSV(data []byte) error {
+	return forEachCSVRecord(data, func(record map[string]string) error {
+		row := make(map[string]interface{}, len(record))
+		for key, val := range record {
+			if val != "" {
+				row[key] = val
+			}
+		}
+		*flattened = append(*flattened, FlattenedSampleDump{Data: row})
+		return nil
+	})
+}
+
+func (flattened *FlattenedSampleDumps) FromParquet(data []byte) error {
+	rows, err := readParquetRows(data)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		*flattened = append(*flattened, FlattenedSampleDump{Data: row})
+	}
+	return nil
+}
+
+func (flattened *FlattenedSampleDumps) AsSampleDumps() (SampleDumps, error) {
+	return UnflattenDumps(*flattened)
+}
+
+// UnflattenDumps is the counterpart of FlattenDumps.
+func UnflattenDumps(flattened FlattenedSampleDumps) (SampleDumps, error) {
+	dumps := make(SampleDumps, 0, len(flattened))
+	for _, single := range flattened {
+		dump, err := sampleDumpFromRow(single.Data, true)
+		if err != nil {
+			return nil, err
+		}
+		dumps = append(dumps, dump)
+	}
+	return dumps, nil
+}
+
+// sampleDumpFromRow reads nested rows or, if flat is set, flattened ones.
+func sampleDumpFromRow(row map[string]interface{}, flat bool) (SampleDump, error) {
+	dump := SampleDump{Labels: make(model.LabelSet)}
+	var err error
+	dump.Timestamp, err = toInt64(row["timestamp"])
+	if err != nil {
+		return dump, err
+	}
+	dump.Value, err = toFloat64(row["value"])
+	if err != nil {
+		return dump, err
+	}
+	if metric, ok := row["metric"]; ok && metric != nil {
+		dump.Metric = fmt.Sprint(metric)
+	}
+	if !flat {
+		labels, _ := row["labels"].(map[string]string)
+		for key, val := range labels {
+			dump.Labels[model.LabelName(key)] = model.LabelValue(val)
+		}
+		return dump, nil
+	}
+	for key, val := range row {
+		if key == "metric" || key == "timestamp" || key == "value" || val == nil {
+			continue
+		}
+		dump.Labels[model.LabelName(key)] = model.LabelValue(fmt.Sprint(val))
+	}
+	return dump, nil
+}
+
+func toInt64(val interface{}) (int64, error) {
+	switch typed := val.(type) {
+	case int64:
+		return typed, nil
+	case int32:
+		return int64(typed), nil
+	case float64:
+		return int64(typed), nil
+	case json.Number:
+		return typed.Int64()
+	case string:
+		return strconv.ParseInt(typed, 10, 64)
+	}
+	return 0, fmt.Errorf("invalid timestamp: %v", val)
+}
+
+func toFloat64(val interface{}) (float64, error) {
+	switch typed := val.(type) {
+	case float64:
+		return typed, nil
+	case float32:
+		return float64(typed), nil
+	case int64:
+		return float64(typed), nil
+	case json.Number:
+		return typed.Float64()
+	case string:
+		return strconv.ParseFloat(typed, 64)
+	}
+	return 0, fmt.Errorf("invalid value: %v", val)
+}
+
+func forEachLine(data []byte, fn func(line []byte) error) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func forEachCSVRecord(data []byte, fn func(record map[string]string) error) error {
+	reader := csv.NewReader(bytes.NewReader(data))
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse csv header: %w", err)
+	}
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse csv: %w", err)
+		}
+		record := make(map[string]string, len(header))
+		for i, column := range header {
+			record[column] = fields[i]
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
+
+// readParquetRows reads all rows of a parquet file with primitive or map
+// columns, keyed by column name. Missing optional values are left out.
+func readParquetRows(data []byte) ([]map[string]interface{}, error) {
+	pr, err := reader.NewParquetReader(buffer.NewBufferFileFromBytes(data), nil, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read parquet footer: %w", err)
+	}
+	defer pr.ReadStop()
+	elements := pr.SchemaHandler.SchemaElements
+	// the generated row type has one field per top level column
+	names := make([]string, 0)
+	for i := 1; i < len(elements); i = skipParquetElement(elements, i) {
+		names = append(names, pr.SchemaHandler.Infos[i].ExName)
+	}
+	read, err := pr.ReadByNumber(int(pr.GetNumRows()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read parquet rows: %w", err)
+	}
+	rows := make([]map[string]interface{}, 0, len(read))
+	for _, r := range read {
+		value := reflect.ValueOf(r)
+		if value.Kind() == reflect.Ptr {
+			value = value.Elem()
+		}
+		row := make(map[string]interface{}, len(names))
+		for i, name := range names {
+			field := value.Field(i)
+			if field.Kind() == reflect.Ptr {
+				if field.IsNil() {
+					continue
+				}
+				field = field.Elem()
+			}
+			if field.Kind() == reflect.Map {
+				labels := make(map[string]string, field.Len())
+				iter := field.MapRange()
+				for iter.Next() {
+					labels[fmt.Sprint(iter.Key().Interface())] = fmt.Sprint(reflect.Indirect(iter.Value()).Interface())
+				}
+				row[name] = labels
+				continue
+			}
+			row[name] = field.Interface()
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// skipParquetElement returns the index of the next sibling of the schema element at i.
+func skipParquetElement(elements []*parquet.SchemaElement, i int) int {
+	children := int(elements[i].GetNumChildren())
+	i++
+	for c := 0; c < children; c++ {
+		i = skipParquetElement(elements, i)
+	}
+	return i
+}
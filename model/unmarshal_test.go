@@ -0,0 +1,150 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+// roundTripMatrix has series with differing label sets, values that need
+// full float precision and label values that need escaping in every format.
+func roundTripMatrix() model.Matrix {
+	return model.Matrix{
+		&model.SampleStream{
+			Metric: model.Metric{model.MetricNameLabel: "up", "job": "node", "instance": "node-1:9100"},
+			Values: []model.SamplePair{{Timestamp: 1696154400000, Value: 1}, {Timestamp: 1696154460000, Value: 0}},
+		},
+		&model.SampleStream{
+			Metric: model.Metric{model.MetricNameLabel: "http_requests_total", "path": `/a,"b"` + "\n/c", "code": "200"},
+			Values: []model.SamplePair{{Timestamp: 1696154400123, Value: 0.1}, {Timestamp: 1696240800000, Value: 1e-300}},
+		},
+		&model.SampleStream{
+			Metric: model.Metric{model.MetricNameLabel: "node_load1", "job": "node", "instance": "node-2:9100", "cluster": "eu=de/1%"},
+			Values: []model.SamplePair{{Timestamp: 1696154400000, Value: -123456.789}},
+		},
+	}
+}
+
+func sortedMatrix(matrix model.Matrix) model.Matrix {
+	sort.Slice(matrix, func(i, j int) bool { return matrix[i].Metric.Before(matrix[j].Metric) })
+	for _, stream := range matrix {
+		sort.Slice(stream.Values, func(i, j int) bool { return stream.Values[i].Timestamp < stream.Values[j].Timestamp })
+	}
+	return matrix
+}
+
+func TestRoundTrip(t *testing.T) {
+	cases := []struct {
+		layout Layout
+		format Format
+	}{
+		{LayoutRaw, FormatJSON},
+		{LayoutRaw, FormatNDJSON},
+		{LayoutNested, FormatJSON},
+		{LayoutNested, FormatNDJSON},
+		{LayoutNested, FormatCSV},
+		{LayoutNested, FormatParquet},
+		{LayoutFlat, FormatJSON},
+		{LayoutFlat, FormatNDJSON},
+		{LayoutFlat, FormatCSV},
+		{LayoutFlat, FormatParquet},
+	}
+	for _, c := range cases {
+		for _, values := range [][]model.Value{
+			{roundTripMatrix()},
+			{roundTripMatrix()[:1], roundTripMatrix()[1:]},
+		} {
+			// marshaling removes the metric names from the values
+			expected := make(model.Matrix, 0)
+			for _, value := range values {
+				for _, stream := range value.(model.Matrix) {
+					expected = append(expected, &model.SampleStream{Metric: stream.Metric.Clone(), Values: stream.Values})
+				}
+			}
+			data, err := MarshalSlice(values, c.layout, c.format)
+			if err != nil {
+				t.Fatalf("%s %s: %s", c.layout, c.format, err)
+			}
+			// a single raw value in ndjson is a valid json document as well
+			format, err := DetectFormat(data)
+			if err != nil || format != c.format && !(c.layout == LayoutRaw && len(values) == 1) {
+				t.Errorf("%s %s: detected format %s, %v", c.layout, c.format, format, err)
+			}
+			layout, err := DetectLayout(data, format)
+			if err != nil || layout != c.layout {
+				t.Errorf("%s %s: detected layout %s, %v", c.layout, c.format, layout, err)
+			}
+			dumps, err := UnmarshalSampleDumps(data, layout, format)
+			if err != nil {
+				t.Fatalf("%s %s: %s", c.layout, c.format, err)
+			}
+			actual := dumps.AsMatrix()
+			if !reflect.DeepEqual(sortedMatrix(actual), sortedMatrix(expected)) {
+				t.Errorf("%s %s with %d values: read back\n%v\nwant\n%v", c.layout, c.format, len(values), actual, expected)
+			}
+		}
+	}
+}
+
+func TestPartitionedRoundTrip(t *testing.T) {
+	for _, format := range []Format{FormatJSON, FormatNDJSON, FormatCSV, FormatParquet} {
+		for _, keys := range [][]string{{PartitionMetric}, {PartitionMetric, PartitionDate}, {"cluster", "job"}, {PartitionDate, "path"}} {
+			expected := roundTripMatrix()
+			cfg := PartitionConfig{Keys: keys, MaxRowsPerFile: 1, Format: format}
+			dumps := SampleDumps{}
+			err := MarshalPartitioned([]model.Value{roundTripMatrix()}, cfg, func(part Part) error {
+				buf := bytes.Buffer{}
+				if err := part.Write(&buf); err != nil {
+					return err
+				}
+				read, err := UnmarshalPart(part.Path, buf.Bytes(), format)
+				if err != nil {
+					return err
+				}
+				if len(read) != part.Rows {
+					t.Errorf("%s %v: %s has %d rows, read %d", format, keys, part.Path, part.Rows, len(read))
+				}
+				dumps = append(dumps, read...)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("%s %v: %s", format, keys, err)
+			}
+			actual := dumps.AsMatrix()
+			if !reflect.DeepEqual(sortedMatrix(actual), sortedMatrix(expected)) {
+				t.Errorf("%s %v: read back\n%v\nwant\n%v", format, keys, actual, expected)
+			}
+		}
+	}
+}
+
+func TestPartitionColumns(t *testing.T) {
+	columns, err := partitionColumns("metric=up/cluster=eu%3Dde%2F1%25/job=__HIVE_DEFAULT_PARTITION__/part-0.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(columns, map[string]string{"metric": "up", "cluster": "eu=de/1%"}) {
+		t.Errorf("unexpected columns %v", columns)
+	}
+	for _, path := range []string{"up/part-0.csv", "metric=%4/part-0.csv", "metric=%zz/part-0.csv"} {
+		if _, err := partitionColumns(path); err == nil {
+			t.Errorf("expected %s to be rejected", path)
+		}
+	}
+}
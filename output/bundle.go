@@ -0,0 +1,99 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// IsTar reports whether path names a tar archive like dump.tar or dump.tar.gz.
+func IsTar(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	if _, compressed := compressionExtensions[ext]; compressed {
+		path = strings.TrimSuffix(path, filepath.Ext(path))
+		ext = strings.ToLower(filepath.Ext(path))
+	}
+	return ext == ".tar"
+}
+
+// Bundle writes files into a tar archive. It only writes sequentially, so
+// the archive can be streamed.
+type Bundle struct {
+	writer  *tar.Writer
+	modTime time.Time
+}
+
+func NewBundle(w io.Writer) *Bundle {
+	return &Bundle{writer: tar.NewWriter(w), modTime: time.Now().Truncate(time.Second)}
+}
+
+// Add writes data as a regular file with the slash separated name.
+func (b *Bundle) Add(name string, data []byte) error {
+	err := b.writer.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(len(data)),
+		Mode:     int64(FileMode),
+		ModTime:  b.modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = b.writer.Write(data)
+	return err
+}
+
+// AddFrom adds a regular file with the data written by write. Tar headers
+// carry the size of the file, so the data is spooled to a temporary file
+// instead of being held in memory.
+func (b *Bundle) AddFrom(name string, write func(w io.Writer) error) error {
+	spool, err := os.CreateTemp("", "promdump-bundle-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	if err := write(spool); err != nil {
+		return err
+	}
+	size, err := spool.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	err = b.writer.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     int64(FileMode),
+		ModTime:  b.modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(b.writer, spool)
+	return err
+}
+
+// Close writes the end of the archive but does not close the underlying writer.
+func (b *Bundle) Close() error {
+	return b.writer.Close()
+}
@@ -0,0 +1,348 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sapcc/promdump/compressor"
+	"github.com/sapcc/promdump/model"
+)
+
+const FileMode os.FileMode = 0o644
+
+var compressionExtensions = map[string]compressor.Compression{
+	".gz":     compressor.CompressionGzip,
+	".zst":    compressor.CompressionZstd,
+	".zstd":   compressor.CompressionZstd,
+	".lz4":    compressor.CompressionLZ4,
+	".sz":     compressor.CompressionSnappy,
+	".snappy": compressor.CompressionSnappy,
+	".xz":     compressor.CompressionXZ,
+	".bz2":    compressor.CompressionBzip2,
+}
+
+var formatExtensions = map[string]model.Format{
+	".json":    model.FormatJSON,
+	".ndjson":  model.FormatNDJSON,
+	".jsonl":   model.FormatNDJSON,
+	".csv":     model.FormatCSV,
+	".parquet": model.FormatParquet,
+}
+
+// Extension returns the file extension of compression, which is empty for none.
+func Extension(compression compressor.Compression) string {
+	switch compression {
+	case compressor.CompressionNone:
+		return ""
+	case compressor.CompressionZstd:
+		return ".zst"
+	case compressor.CompressionSnappy:
+		return ".sz"
+	}
+	for ext, c := range compressionExtensions {
+		if c == compression {
+			return ext
+		}
+	}
+	return "." + string(compression)
+}
+
+// Infer derives format and compression from the extensions of path like
+// .csv.gz or .ndjson.zst. Parts that cannot be inferred are returned empty,
+// a known format without compression extension yields compression none.
+func Infer(path string) (model.Format, compressor.Compression) {
+	ext := strings.ToLower(filepath.Ext(path))
+	compression, compressed := compressionExtensions[ext]
+	if compressed {
+		path = strings.TrimSuffix(path, filepath.Ext(path))
+		ext = strings.ToLower(filepath.Ext(path))
+	}
+	format, ok := formatExtensions[ext]
+	if !ok {
+		return "", compression
+	}
+	if !compressed {
+		compression = compressor.CompressionNone
+	}
+	return format, compression
+}
+
+// Output is the destination of a dump. Nothing is visible at the destination
+// before Commit, Abort discards everything written so far.
+type Output interface {
+	io.Writer
+	Commit() error
+	Abort()
+}
+
+// Open returns stdout if path is empty or - and a File otherwise.
+func Open(path string, force bool) (Output, error) {
+	if path == "" || path == "-" {
+		return stdout{}, nil
+	}
+	return Create(path, force)
+}
+
+type stdout struct{}
+
+func (stdout) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+func (stdout) Commit() error {
+	return nil
+}
+
+func (stdout) Abort() {}
+
+// File is written to a temporary file next to path, which is renamed into place on Commit.
+type File struct {
+	path  string
+	force bool
+	tmp   *os.File
+	done  bool
+}
+
+// Create fails if path exists already, unless force is set.
+func Create(path string, force bool) (*File, error) {
+	if err := CheckOverwrite(path, force); err != nil {
+		return nil, err
+	}
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".promdump-*")
+	if err != nil {
+		return nil, err
+	}
+	return &File{path: path, force: force, tmp: tmp}, nil
+}
+
+// CheckOverwrite fails if path exists already, unless force is set.
+func CheckOverwrite(path string, force bool) error {
+	if force {
+		return nil
+	}
+	_, err := os.Lstat(path)
+	if err == nil {
+		return fmt.Errorf("%s exists already, use --force to overwrite it", path)
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	return f.tmp.Write(p)
+}
+
+func (f *File) Commit() error {
+	if f.done {
+		return fmt.Errorf("output %s is closed already", f.path)
+	}
+	f.done = true
+	err := f.tmp.Chmod(FileMode)
+	if err == nil {
+		err = f.tmp.Sync()
+	}
+	if closeErr := f.tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = CheckOverwrite(f.path, f.force)
+	}
+	if err == nil {
+		err = os.Rename(f.tmp.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(f.tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Abort removes the temporary file, it does nothing after Commit.
+func (f *File) Abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.tmp.Close()
+	os.Remove(f.tmp.Name())
+}
+
+// Compressed compresses everything written to it into an Output and keeps
+// track of the SHA-256 of the compressed data.
+type Compressed struct {
+	writer  io.WriteCloser
+	hash    hash.Hash
+	out     Output
+	flushed bool
+}
+
+// OpenCompressed is Open with everything written being compressed according
+// to the compression spec, see compressor.NewWriter.
+func OpenCompressed(path string, force bool, compression string) (*Compressed, error) {
+	out, err := Open(path, force)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.New()
+	writer, err := compressor.NewWriter(io.MultiWriter(out, digest), compression)
+	if err != nil {
+		out.Abort()
+		return nil, err
+	}
+	return &Compressed{writer: writer, hash: digest, out: out}, nil
+}
+
+func (c *Compressed) Write(p []byte) (int, error) {
+	return c.writer.Write(p)
+}
+
+// Flush finishes the compression, so SHA256 is complete before Commit.
+// Nothing can be written afterwards.
+func (c *Compressed) Flush() error {
+	if c.flushed {
+		return nil
+	}
+	c.flushed = true
+	return c.writer.Close()
+}
+
+func (c *Compressed) Commit() error {
+	if err := c.Flush(); err != nil {
+		c.out.Abort()
+		return err
+	}
+	return c.out.Commit()
+}
+
+func (c *Compressed) Abort() {
+	c.out.Abort()
+}
+
+// SHA256 returns the hex encoded checksum of the data written so far, it is complete after Flush.
+func (c *Compressed) SHA256() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+// Dir is written to a temporary directory next to path, which is renamed into place on Commit.
+type Dir struct {
+	path   string
+	force  bool
+	marker string
+	tmp    string
+	done   bool
+}
+
+// CreateDir fails if path exists already, unless force is set and path is a
+// directory containing the file marker, i.e. was written by an earlier run.
+func CreateDir(path string, force bool, marker string) (*Dir, error) {
+	if _, err := checkReplaceDir(path, force, marker); err != nil {
+		return nil, err
+	}
+	parent, base := filepath.Split(filepath.Clean(path))
+	if parent == "" {
+		parent = "."
+	}
+	tmp, err := os.MkdirTemp(parent, "."+base+".promdump-*")
+	if err != nil {
+		return nil, err
+	}
+	return &Dir{path: path, force: force, marker: marker, tmp: tmp}, nil
+}
+
+// checkReplaceDir is CheckOverwrite for directories, it reports whether path exists.
+func checkReplaceDir(path string, force bool, marker string) (bool, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !force {
+		return true, fmt.Errorf("%s exists already, use --force to overwrite it", path)
+	}
+	if !info.IsDir() {
+		return true, fmt.Errorf("%s is not a directory, refusing to replace it", path)
+	}
+	if _, err := os.Lstat(filepath.Join(path, marker)); err != nil {
+		return true, fmt.Errorf("%s contains no %s, refusing to replace a directory not written by promdump", path, marker)
+	}
+	return true, nil
+}
+
+// Create creates a file at the slash separated path relative to the directory.
+func (d *Dir) Create(name string) (*os.File, error) {
+	full := filepath.Join(d.tmp, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(full, os.O_WRONLY|os.O_CREATE|os.O_EXCL, FileMode)
+}
+
+// Commit replaces an existing directory at path only if force is set. The
+// existing directory is moved aside and only removed once the new one is in place.
+func (d *Dir) Commit() error {
+	if d.done {
+		return fmt.Errorf("output %s is closed already", d.path)
+	}
+	d.done = true
+	err := os.Chmod(d.tmp, 0o755)
+	exists := false
+	if err == nil {
+		exists, err = checkReplaceDir(d.path, d.force, d.marker)
+	}
+	aside := d.tmp + ".old"
+	if err == nil && exists {
+		err = os.Rename(d.path, aside)
+	}
+	if err == nil {
+		err = os.Rename(d.tmp, d.path)
+		if err != nil && exists {
+			if restoreErr := os.Rename(aside, d.path); restoreErr != nil {
+				err = fmt.Errorf("%w, the previous content of %s is left at %s", err, d.path, aside)
+			}
+		}
+	}
+	if err != nil {
+		os.RemoveAll(d.tmp)
+		return err
+	}
+	if exists {
+		return os.RemoveAll(aside)
+	}
+	return nil
+}
+
+// Abort removes the temporary directory, it does nothing after Commit.
+func (d *Dir) Abort() {
+	if d.done {
+		return
+	}
+	d.done = true
+	os.RemoveAll(d.tmp)
+}
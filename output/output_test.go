@@ -0,0 +1,281 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sapcc/promdump/compressor"
+	"github.com/sapcc/promdump/model"
+)
+
+func TestInfer(t *testing.T) {
+	cases := []struct {
+		path        string
+		format      model.Format
+		compression compressor.Compression
+	}{
+		{"dump.json", model.FormatJSON, compressor.CompressionNone},
+		{"dump.jsonl", model.FormatNDJSON, compressor.CompressionNone},
+		{"dump.csv.gz", model.FormatCSV, compressor.CompressionGzip},
+		{"out/dump.NDJSON.ZST", model.FormatNDJSON, compressor.CompressionZstd},
+		{"dump.parquet", model.FormatParquet, compressor.CompressionNone},
+		{"dump.gz", "", compressor.CompressionGzip},
+		{"dump.txt", "", ""},
+		{"dump", "", ""},
+		{"", "", ""},
+	}
+	for _, c := range cases {
+		format, compression := Infer(c.path)
+		if format != c.format || compression != c.compression {
+			t.Errorf("%q: inferred %q and %q, want %q and %q", c.path, format, compression, c.format, c.compression)
+		}
+	}
+}
+
+// leftovers returns the temporary files left in dir.
+func leftovers(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".promdump-") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+func TestFileIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dump.json")
+	file, err := Create(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("[]")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected nothing at %s before the commit, got %v", path, err)
+	}
+	if err := file.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[]" {
+		t.Errorf("expected [], got %s", data)
+	}
+	if err := file.Commit(); err == nil {
+		t.Error("expected a second commit to fail")
+	}
+
+	// an aborted write leaves the existing file alone
+	file, err = Create(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("[1]")); err != nil {
+		t.Fatal(err)
+	}
+	file.Abort()
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[]" {
+		t.Errorf("expected the aborted write to be discarded, got %s", data)
+	}
+	if names := leftovers(t, dir); len(names) != 0 {
+		t.Errorf("temporary files were left behind: %v", names)
+	}
+}
+
+func TestFileRefusesExistingWithoutForce(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dump.json")
+	if err := os.WriteFile(path, []byte("old"), FileMode); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Create(path, false); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("expected an existing file to be refused, got %v", err)
+	}
+
+	// the check is repeated on commit, in case the file was created in the meantime
+	other := filepath.Join(dir, "other.json")
+	file, err := Create(other, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(other, []byte("other"), FileMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Commit(); err == nil {
+		t.Error("expected the commit to fail after the file was created")
+	}
+	data, err := os.ReadFile(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "other" {
+		t.Errorf("file was overwritten with %s", data)
+	}
+
+	file, err = Create(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Errorf("expected the file to be overwritten with --force, got %s", data)
+	}
+	if names := leftovers(t, dir); len(names) != 0 {
+		t.Errorf("temporary files were left behind: %v", names)
+	}
+}
+
+func TestOpenCompressed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.json.gz")
+	out, err := OpenCompressed(path, false, "gzip:9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := out.Write([]byte(`{"metric":"up"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"metric":"up"}` {
+		t.Errorf("expected the written data after decompression, got %s", data)
+	}
+
+	if _, err := OpenCompressed(filepath.Join(t.TempDir(), "dump.json"), false, "gzip:fast"); err == nil {
+		t.Error("expected an invalid compression to be refused")
+	}
+}
+
+const testMarker = "_marker.json"
+
+func writeDir(t *testing.T, path string, force bool, files ...string) error {
+	t.Helper()
+	dir, err := CreateDir(path, force, testMarker)
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		file, err := dir.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+	}
+	return dir.Commit()
+}
+
+func TestDirReplacesOnlyMarkedDirectories(t *testing.T) {
+	parent := t.TempDir()
+	path := filepath.Join(parent, "dataset")
+	if err := writeDir(t, path, false, "metric=up/part-0.csv", testMarker); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeDir(t, path, false, testMarker); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("expected an existing directory to be refused without force, got %v", err)
+	}
+	if err := writeDir(t, path, true, "metric=down/part-0.csv", testMarker); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(path, "metric=up")); !os.IsNotExist(err) {
+		t.Errorf("expected the old content to be replaced, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(path, "metric=down", "part-0.csv")); err != nil {
+		t.Error(err)
+	}
+
+	// directories and files not written by promdump are never replaced
+	home := filepath.Join(parent, "home")
+	if err := os.MkdirAll(filepath.Join(home, "documents"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(parent, "file")
+	if err := os.WriteFile(file, nil, FileMode); err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []string{home, file} {
+		if err := writeDir(t, target, true, testMarker); err == nil || !strings.Contains(err.Error(), "refusing") {
+			t.Errorf("expected %s to be refused, got %v", target, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(home, "documents")); err != nil {
+		t.Errorf("unmarked directory was touched: %s", err)
+	}
+
+	// the check is repeated on commit, in case the directory changed in the meantime
+	dir, err := CreateDir(path, true, testMarker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(path, testMarker)); err != nil {
+		t.Fatal(err)
+	}
+	if err := dir.Commit(); err == nil {
+		t.Error("expected the commit to fail after the marker was removed")
+	}
+	if _, err := os.Stat(filepath.Join(path, "metric=down", "part-0.csv")); err != nil {
+		t.Errorf("directory was touched: %s", err)
+	}
+
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".promdump-") {
+			t.Errorf("temporary directory %s was left behind", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const maxAliasLength = 64
+
+// an alias is prefixed as ALIAS=, which is never valid PromQL on its own
+var aliasPattern = regexp.MustCompile(`(?s)^([A-Za-z_][A-Za-z0-9_.-]*)=([^=~].*)$`)
+
+var unsafeAliasChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// ParseQueries parses specs of the form ALIAS=EXPR or EXPR. Missing aliases
+// are derived from the expression, duplicates get a numeric suffix.
+func ParseQueries(specs []string) []Query {
+	queries := make([]Query, 0, len(specs))
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		q := Query{Expr: spec}
+		if match := aliasPattern.FindStringSubmatch(spec); match != nil {
+			q = Query{Alias: match[1], Expr: match[2]}
+		} else {
+			q.Alias = sanitizeAlias(spec, "query")
+		}
+		q.Alias = uniqueAlias(q.Alias, seen)
+		queries = append(queries, q)
+	}
+	return queries
+}
+
+// ParseTargets parses specs of the form ALIAS=URL or URL. Missing aliases
+// are derived from the host of the url, duplicates get a numeric suffix.
+func ParseTargets(specs []string) []Target {
+	targets := make([]Target, 0, len(specs))
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		target := Target{URL: spec}
+		if match := aliasPattern.FindStringSubmatch(spec); match != nil && strings.Contains(match[2], "://") {
+			target = Target{Alias: match[1], URL: match[2]}
+		} else if parsed, err := url.Parse(spec); err == nil && parsed.Host != "" {
+			target.Alias = sanitizeAlias(parsed.Host, "prometheus")
+		} else {
+			target.Alias = sanitizeAlias(spec, "prometheus")
+		}
+		target.Alias = uniqueAlias(target.Alias, seen)
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+func sanitizeAlias(s, fallback string) string {
+	alias := strings.Trim(unsafeAliasChars.ReplaceAllString(s, "_"), "_.-")
+	if len(alias) > maxAliasLength {
+		alias = strings.TrimRight(alias[:maxAliasLength], "_.-")
+	}
+	if alias == "" {
+		return fallback
+	}
+	return alias
+}
+
+// uniqueAlias appends the first numeric suffix that gives an unused alias,
+// explicit aliases may look like suffixed ones already.
+func uniqueAlias(alias string, seen map[string]bool) string {
+	unique := alias
+	for n := 2; seen[unique]; n++ {
+		unique = fmt.Sprintf("%s-%d", alias, n)
+	}
+	seen[unique] = true
+	return unique
+}
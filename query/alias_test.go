@@ -0,0 +1,55 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQueries(t *testing.T) {
+	cases := []struct {
+		specs   []string
+		aliases []string
+	}{
+		{[]string{"up", "a=up", `rate(x{job="a"}[5m])`}, []string{"up", "a", "rate_x_job_a_5m"}},
+		{[]string{"a=up", "a=down", "a-2=other"}, []string{"a", "a-2", "a-2-2"}},
+		{[]string{"a-2=other", "a=up", "a=down", "a=left"}, []string{"a-2", "a", "a-3", "a-4"}},
+		{[]string{"up", "up", "up-2=x", "up"}, []string{"up", "up-2", "up-2-2", "up-3"}},
+		{[]string{`{__name__=~"a"}`, "=="}, []string{"name___a", "query"}},
+	}
+	for _, c := range cases {
+		queries := ParseQueries(c.specs)
+		aliases := make([]string, 0, len(queries))
+		for _, q := range queries {
+			aliases = append(aliases, q.Alias)
+		}
+		if !reflect.DeepEqual(aliases, c.aliases) {
+			t.Errorf("%q: aliases %q, want %q", c.specs, aliases, c.aliases)
+		}
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+	targets := ParseTargets([]string{"http://prom:9090", "prom=http://other:9090", "http://prom:9090/select", "prom-2=http://third"})
+	expected := []Target{
+		{Alias: "prom_9090", URL: "http://prom:9090"},
+		{Alias: "prom", URL: "http://other:9090"},
+		{Alias: "prom_9090-2", URL: "http://prom:9090/select"},
+		{Alias: "prom-2", URL: "http://third"},
+	}
+	if !reflect.DeepEqual(targets, expected) {
+		t.Errorf("got %+v, want %+v", targets, expected)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	prommodel "github.com/prometheus/common/model"
+)
+
+type CardinalitySource string
+
+const (
+	// CardinalityNone skips cardinality statistics.
+	CardinalityNone CardinalitySource = ""
+	// CardinalitySeries counts the series of every metric within the time window.
+	CardinalitySeries CardinalitySource = "series"
+	// CardinalityTSDB uses the head statistics, which only cover the metrics with the most series.
+	CardinalityTSDB CardinalitySource = "tsdb"
+)
+
+func ParseCardinalitySource(s string) (CardinalitySource, error) {
+	switch source := CardinalitySource(s); source {
+	case CardinalityNone, CardinalitySeries, CardinalityTSDB:
+		return source, nil
+	default:
+		return CardinalityNone, fmt.Errorf("unknown cardinality source %q, must be series or tsdb", s)
+	}
+}
+
+type Cardinality struct {
+	Series int                `json:"series"`
+	Labels []LabelCardinality `json:"labels,omitempty"`
+}
+
+type LabelCardinality struct {
+	Name   string `json:"name"`
+	Values int    `json:"values"`
+	// Top are the values with the most series.
+	Top []ValueCount `json:"top"`
+}
+
+type ValueCount struct {
+	Value  string `json:"value"`
+	Series int    `json:"series"`
+}
+
+// seriesCardinality looks up the series of metric and counts them per label value.
+// The label names are returned as well, so no separate lookup is needed.
+func seriesCardinality(ctx context.Context, api v1.API, metric string, cfg MetricsConfig) ([]string, *Cardinality, error) {
+	series, warns, err := api.Series(ctx, []string{metric}, cfg.Start, cfg.End)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to look up series of %s: %w", metric, err)
+	}
+	printWarnings(warns)
+	counts := make(map[string]map[string]int)
+	for _, labelSet := range series {
+		for name, value := range labelSet {
+			if counts[string(name)] == nil {
+				counts[string(name)] = make(map[string]int)
+			}
+			counts[string(name)][string(value)]++
+		}
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cardinality := &Cardinality{Series: len(series), Labels: make([]LabelCardinality, 0, len(names))}
+	for _, name := range names {
+		if name == prommodel.MetricNameLabel {
+			continue
+		}
+		values := make([]ValueCount, 0, len(counts[name]))
+		for value, count := range counts[name] {
+			values = append(values, ValueCount{Value: value, Series: count})
+		}
+		sort.Slice(values, func(i, j int) bool {
+			if values[i].Series != values[j].Series {
+				return values[i].Series > values[j].Series
+			}
+			return values[i].Value < values[j].Value
+		})
+		label := LabelCardinality{Name: name, Values: len(values), Top: values}
+		if cfg.TopValues >= 0 && len(values) > cfg.TopValues {
+			label.Top = values[:cfg.TopValues]
+		}
+		cardinality.Labels = append(cardinality.Labels, label)
+	}
+	return names, cardinality, nil
+}
+
+// tsdbCardinality sets the series counts of the metrics reported by the tsdb status.
+// These only cover the head block and its metrics with the most series, labels are not counted.
+func tsdbCardinality(ctx context.Context, api v1.API, dumps []MetricDump) error {
+	status, err := api.TSDB(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get the tsdb status: %w", err)
+	}
+	seriesCounts := make(map[string]int, len(status.SeriesCountByMetricName))
+	for _, stat := range status.SeriesCountByMetricName {
+		seriesCounts[stat.Name] = int(stat.Value)
+	}
+	for i := range dumps {
+		if count, ok := seriesCounts[dumps[i].Name]; ok {
+			dumps[i].Cardinality = &Cardinality{Series: count}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type LabelsConfig struct {
+	// Names are the labels to look up the values of, all label names are listed if empty.
+	Names   []string
+	Matches []string
+	Start   time.Time
+	End     time.Time
+}
+
+// Label is a label name of a prometheus with its values, which are only set
+// if values were looked up.
+type Label struct {
+	Target Target
+	Name   string
+	Values []string
+}
+
+// Labels looks up label names or the values of cfg.Names on all targets
+// concurrently. The results are ordered by target and label.
+func Labels(ctx context.Context, targets []Target, cfg LabelsConfig, httpClient *http.Client) ([]Label, error) {
+	perTarget := make([][]Label, len(targets))
+	err := forEachTarget(targets, func(i int) error {
+		var err error
+		perTarget[i], err = labels(ctx, targets[i], cfg, httpClient)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Label, 0)
+	for _, current := range perTarget {
+		result = append(result, current...)
+	}
+	return result, nil
+}
+
+func labels(ctx context.Context, target Target, cfg LabelsConfig, httpClient *http.Client) ([]Label, error) {
+	api, err := newAPI(target.URL, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Names) == 0 {
+		names, warns, err := api.LabelNames(ctx, cfg.Matches, cfg.Start, cfg.End)
+		if err != nil {
+			return nil, err
+		}
+		printWarnings(warns)
+		result := make([]Label, 0, len(names))
+		for _, name := range names {
+			result = append(result, Label{Target: target, Name: name})
+		}
+		return result, nil
+	}
+	result := make([]Label, 0, len(cfg.Names))
+	for _, name := range cfg.Names {
+		values, warns, err := api.LabelValues(ctx, name, cfg.Matches, cfg.Start, cfg.End)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up values of %s: %w", name, err)
+		}
+		printWarnings(warns)
+		label := Label{Target: target, Name: name, Values: make([]string, 0, len(values))}
+		for _, value := range values {
+			label.Values = append(label.Values, string(value))
+		}
+		result = append(result, label)
+	}
+	return result, nil
+}
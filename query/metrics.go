@@ -17,67 +17,355 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"os"
+	"regexp"
+	"sort"
+	"sync"
 	"time"
 
-	"github.com/prometheus/client_golang/api"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	prommodel "github.com/prometheus/common/model"
 )
 
+// MetricInfo merges all metadata variants of a metric. Type, Unit and Help
+// are taken from the first variant, Conflicts names the fields that differ
+// between the variants, e.g. because targets expose different versions.
 type MetricInfo struct {
-	Name string `json:"name"`
-	Help string `json:"help"`
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Unit      string   `json:"unit"`
+	Help      string   `json:"help"`
+	Helps     []string `json:"helps"`
+	Conflicts []string `json:"conflicts,omitempty"`
 }
 
 type MetricDump struct {
 	MetricInfo
-	Labels []string `json:"labels"`
+	Labels      []string     `json:"labels"`
+	Cardinality *Cardinality `json:"cardinality,omitempty"`
+	// Servers, MissingFrom and PartialLabels are only set when several
+	// prometheis are compared. Labels is the union of their label names then.
+	Servers       []ServerMetric `json:"servers,omitempty"`
+	MissingFrom   []string       `json:"missingFrom,omitempty"`
+	PartialLabels []string       `json:"partialLabels,omitempty"`
 }
 
-func MetricsWithLabels(ctx context.Context, url string, httpClient *http.Client) ([]MetricDump, error) {
-	client, err := api.NewClient(api.Config{
-		Address: url,
-		Client:  httpClient,
-	})
+// ServerMetric is a metric as exposed by a single prometheus.
+type ServerMetric struct {
+	Server      string       `json:"server"`
+	Labels      []string     `json:"labels"`
+	Cardinality *Cardinality `json:"cardinality,omitempty"`
+}
+
+// Partial reports whether the metric or some of its labels are missing from some prometheis.
+func (dump *MetricDump) Partial() bool {
+	return len(dump.MissingFrom) > 0 || len(dump.PartialLabels) > 0
+}
+
+// MetricsConfig restricts the metrics looked up by MetricsWithLabels.
+type MetricsConfig struct {
+	// Start and End bound the label and series lookups.
+	Start time.Time
+	End   time.Time
+	// Matches are series selectors, only metrics with matching series are kept.
+	Matches []string
+	// Names are regexes anchored like in PromQL, metrics have to match one of them.
+	Names []*regexp.Regexp
+	// Concurrency limits the number of concurrent label lookups, across all prometheis.
+	Concurrency int
+	// NoLabels skips the label lookups, so only the metadata endpoint is queried.
+	NoLabels bool
+	// Cardinality adds series counts from the given source.
+	Cardinality CardinalitySource
+	// TopValues limits the values listed per label, -1 means all.
+	TopValues int
+}
+
+func MetricsWithLabels(ctx context.Context, url string, cfg MetricsConfig, httpClient *http.Client) ([]MetricDump, error) {
+	return metricsWithLabels(ctx, url, cfg, newSemaphore(cfg.Concurrency), httpClient)
+}
+
+// metricsWithLabels runs the label lookups within semaphore, so it can be shared between prometheis.
+func metricsWithLabels(ctx context.Context, url string, cfg MetricsConfig, semaphore chan struct{}, httpClient *http.Client) ([]MetricDump, error) {
+	api, err := newAPI(url, httpClient)
 	if err != nil {
 		return nil, err
 	}
-	api := v1.NewAPI(client)
 	metaMap, err := api.Metadata(ctx, "", "")
 	if err != nil {
 		return nil, err
 	}
-	metrics := make([]MetricInfo, 0)
-	for name, val := range metaMap {
-		for _, info := range val {
-			metrics = append(metrics, MetricInfo{
-				Name: name,
-				Help: info.Help,
-			})
+	var matched map[string]struct{}
+	if len(cfg.Matches) > 0 {
+		names, warns, err := api.LabelValues(ctx, prommodel.MetricNameLabel, cfg.Matches, cfg.Start, cfg.End)
+		if err != nil {
+			return nil, err
+		}
+		printWarnings(warns)
+		matched = make(map[string]struct{}, len(names))
+		for _, name := range names {
+			matched[string(name)] = struct{}{}
 		}
 	}
-	uniqueMetrics := make(map[string]struct{})
-	for _, metric := range metrics {
-		uniqueMetrics[metric.Name] = struct{}{}
+	metrics := make([]MetricInfo, 0, len(metaMap))
+	for name, variants := range metaMap {
+		if matched != nil && !seriesMatched(matched, name, variants) {
+			continue
+		}
+		if !matchesAny(cfg.Names, name) {
+			continue
+		}
+		metrics = append(metrics, mergeMetadata(name, variants))
 	}
-	metricLabels := make(map[string][]string)
-	now := time.Now()
-	for metric := range uniqueMetrics {
-		labels, warns, err := api.LabelNames(ctx, []string{metric}, time.UnixMilli(0), now)
-		if err != nil {
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+	dumps := make([]MetricDump, len(metrics))
+	for i, metric := range metrics {
+		dumps[i].MetricInfo = metric
+	}
+	switch {
+	case cfg.Cardinality == CardinalitySeries:
+		err = forEachConcurrently(ctx, len(dumps), semaphore, func(ctx context.Context, i int) error {
+			var err error
+			dumps[i].Labels, dumps[i].Cardinality, err = seriesCardinality(ctx, api, dumps[i].Name, cfg)
+			return err
+		})
+	case !cfg.NoLabels:
+		err = forEachConcurrently(ctx, len(dumps), semaphore, func(ctx context.Context, i int) error {
+			labels, warns, err := api.LabelNames(ctx, []string{dumps[i].Name}, cfg.Start, cfg.End)
+			if err != nil {
+				return fmt.Errorf("failed to look up labels of %s: %w", dumps[i].Name, err)
+			}
+			printWarnings(warns)
+			dumps[i].Labels = labels
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Cardinality == CardinalityTSDB {
+		if err := tsdbCardinality(ctx, api, dumps); err != nil {
 			return nil, err
 		}
-		for _, warn := range warns {
-			fmt.Fprintf(os.Stderr, "Prometheus API warning: %s\n", warn)
+	}
+	return dumps, nil
+}
+
+// MetricsAcross looks up the metrics of all targets concurrently and merges
+// them by name. A single target gives the same result as MetricsWithLabels.
+// The label lookups of all targets share the Concurrency limit.
+func MetricsAcross(ctx context.Context, targets []Target, cfg MetricsConfig, httpClient *http.Client) ([]MetricDump, error) {
+	if len(targets) == 1 {
+		return MetricsWithLabels(ctx, targets[0].URL, cfg, httpClient)
+	}
+	semaphore := newSemaphore(cfg.Concurrency)
+	perTarget := make([][]MetricDump, len(targets))
+	err := forEachTarget(targets, func(i int) error {
+		var err error
+		perTarget[i], err = metricsWithLabels(ctx, targets[i].URL, cfg, semaphore, httpClient)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeTargets(targets, perTarget, !cfg.NoLabels), nil
+}
+
+func mergeTargets(targets []Target, perTarget [][]MetricDump, withLabels bool) []MetricDump {
+	merged := make(map[string]*MetricDump)
+	infos := make(map[string][]MetricInfo)
+	for i, dumps := range perTarget {
+		for _, dump := range dumps {
+			current, ok := merged[dump.Name]
+			if !ok {
+				current = &MetricDump{}
+				merged[dump.Name] = current
+			}
+			infos[dump.Name] = append(infos[dump.Name], dump.MetricInfo)
+			current.Servers = append(current.Servers, ServerMetric{Server: targets[i].Alias, Labels: dump.Labels, Cardinality: dump.Cardinality})
 		}
-		metricLabels[metric] = labels
 	}
-	dumps := make([]MetricDump, 0)
-	for _, metric := range metrics {
-		dumps = append(dumps, MetricDump{
-			MetricInfo: metric,
-			Labels:     metricLabels[metric.Name],
-		})
+	result := make([]MetricDump, 0, len(merged))
+	for name, current := range merged {
+		current.MetricInfo = mergeInfos(infos[name])
+		exposing := make(map[string]struct{}, len(current.Servers))
+		labelCounts := make(map[string]int)
+		for _, server := range current.Servers {
+			exposing[server.Server] = struct{}{}
+			for _, label := range server.Labels {
+				labelCounts[label]++
+			}
+		}
+		for _, target := range targets {
+			if _, ok := exposing[target.Alias]; !ok {
+				current.MissingFrom = append(current.MissingFrom, target.Alias)
+			}
+		}
+		if withLabels {
+			current.Labels = make([]string, 0, len(labelCounts))
+			for label, count := range labelCounts {
+				current.Labels = append(current.Labels, label)
+				if count < len(current.Servers) {
+					current.PartialLabels = append(current.PartialLabels, label)
+				}
+			}
+			sort.Strings(current.Labels)
+			sort.Strings(current.PartialLabels)
+		}
+		result = append(result, *current)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// mergeInfos merges the metadata of a metric from several prometheis like mergeMetadata.
+func mergeInfos(infos []MetricInfo) MetricInfo {
+	merged := infos[0]
+	merged.Helps = make([]string, 0, len(infos[0].Helps))
+	conflicts := make(map[string]struct{})
+	helps := make(map[string]struct{})
+	for _, info := range infos {
+		for _, conflict := range info.Conflicts {
+			conflicts[conflict] = struct{}{}
+		}
+		if info.Type != merged.Type {
+			conflicts["type"] = struct{}{}
+		}
+		if info.Unit != merged.Unit {
+			conflicts["unit"] = struct{}{}
+		}
+		for _, help := range info.Helps {
+			if _, ok := helps[help]; !ok {
+				helps[help] = struct{}{}
+				merged.Helps = append(merged.Helps, help)
+			}
+		}
+	}
+	if len(helps) > 1 {
+		conflicts["help"] = struct{}{}
+	}
+	merged.Conflicts = nil
+	for _, field := range []string{"type", "unit", "help"} {
+		if _, ok := conflicts[field]; ok {
+			merged.Conflicts = append(merged.Conflicts, field)
+		}
+	}
+	return merged
+}
+
+// newSemaphore limits forEachConcurrently to limit calls running at once.
+func newSemaphore(limit int) chan struct{} {
+	if limit < 1 {
+		limit = 1
+	}
+	return make(chan struct{}, limit)
+}
+
+// forEachConcurrently calls fn for 0 to n-1 with a slot of semaphore held
+// for each call and returns the first error. The context of pending calls
+// is cancelled after an error.
+func forEachConcurrently(ctx context.Context, n int, semaphore chan struct{}, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	acquire := func() bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		select {
+		case semaphore <- struct{}{}:
+			// select picks randomly if the context was cancelled while a slot was free
+			if ctx.Err() != nil {
+				<-semaphore
+				return false
+			}
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	for i := 0; i < n && acquire(); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			if err := fn(ctx, i); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// seriesSuffixes are appended to the name of metrics of some types to name
+// their series, e.g. histograms are exposed as _bucket, _count and _sum.
+var seriesSuffixes = map[v1.MetricType][]string{
+	v1.MetricTypeCounter:        {"_total", "_created"},
+	v1.MetricTypeHistogram:      {"_bucket", "_count", "_sum", "_created"},
+	v1.MetricTypeGaugeHistogram: {"_bucket", "_gcount", "_gsum"},
+	v1.MetricTypeSummary:        {"_count", "_sum", "_created"},
+}
+
+// seriesMatched reports whether matched contains one of the series names
+// of the metric, which depend on the types of its metadata variants.
+func seriesMatched(matched map[string]struct{}, name string, variants []v1.Metadata) bool {
+	if _, ok := matched[name]; ok {
+		return true
+	}
+	for _, variant := range variants {
+		for _, suffix := range seriesSuffixes[variant.Type] {
+			if _, ok := matched[name+suffix]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesAny(regexes []*regexp.Regexp, name string) bool {
+	if len(regexes) == 0 {
+		return true
+	}
+	for _, re := range regexes {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeMetadata(name string, variants []v1.Metadata) MetricInfo {
+	info := MetricInfo{Name: name, Helps: make([]string, 0, 1)}
+	types, units, helps := make(map[v1.MetricType]struct{}), make(map[string]struct{}), make(map[string]struct{})
+	for i, variant := range variants {
+		if i == 0 {
+			info.Type, info.Unit, info.Help = string(variant.Type), variant.Unit, variant.Help
+		}
+		types[variant.Type] = struct{}{}
+		units[variant.Unit] = struct{}{}
+		if _, ok := helps[variant.Help]; !ok {
+			helps[variant.Help] = struct{}{}
+			info.Helps = append(info.Helps, variant.Help)
+		}
+	}
+	if len(types) > 1 {
+		info.Conflicts = append(info.Conflicts, "type")
+	}
+	if len(units) > 1 {
+		info.Conflicts = append(info.Conflicts, "unit")
+	}
+	if len(helps) > 1 {
+		info.Conflicts = append(info.Conflicts, "help")
 	}
-	return dumps, err
+	return info
 }
@@ -0,0 +1,287 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// fakeMetrics serves the metadata, labels, series and tsdb status endpoints for series.
+func fakeMetrics(t *testing.T, series []map[string]string) *httptest.Server {
+	respond := func(w http.ResponseWriter, data interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "data": data})
+	}
+	matching := func(r *http.Request) []map[string]string {
+		matched := make([]map[string]string, 0)
+		for _, s := range series {
+			for _, match := range r.Form["match[]"] {
+				if s["__name__"] == match {
+					matched = append(matched, s)
+				}
+			}
+		}
+		return matched
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/metadata":
+			metadata := make(map[string][]map[string]string)
+			for _, s := range series {
+				metadata[s["__name__"]] = []map[string]string{{"type": "gauge", "help": s["__name__"] + " help", "unit": ""}}
+			}
+			respond(w, metadata)
+		case "/api/v1/series":
+			respond(w, matching(r))
+		case "/api/v1/labels":
+			seen := make(map[string]bool)
+			names := make([]string, 0)
+			for _, s := range matching(r) {
+				for name := range s {
+					if !seen[name] {
+						seen[name] = true
+						names = append(names, name)
+					}
+				}
+			}
+			respond(w, names)
+		case "/api/v1/status/tsdb":
+			respond(w, map[string]interface{}{
+				"headStats":                   map[string]interface{}{},
+				"seriesCountByMetricName":     []map[string]interface{}{{"name": "up", "value": 42}},
+				"labelValueCountByLabelName":  []map[string]interface{}{{"name": "instance", "value": 7}},
+				"memoryInBytesByLabelName":    []map[string]interface{}{},
+				"seriesCountByLabelValuePair": []map[string]interface{}{},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMetricsCardinality(t *testing.T) {
+	server := fakeMetrics(t, []map[string]string{
+		{"__name__": "up", "job": "node", "instance": "a"},
+		{"__name__": "up", "job": "node", "instance": "b"},
+		{"__name__": "up", "job": "api", "instance": "c"},
+		{"__name__": "load", "instance": "a"},
+	})
+	cfg := MetricsConfig{Start: time.Unix(0, 0), End: time.Unix(3600, 0), Concurrency: 2, Cardinality: CardinalitySeries, TopValues: 1}
+	dumps, err := MetricsWithLabels(context.Background(), server.URL, cfg, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dumps) != 2 || dumps[1].Name != "up" {
+		t.Fatalf("unexpected metrics %+v", dumps)
+	}
+	expected := &Cardinality{Series: 3, Labels: []LabelCardinality{
+		{Name: "instance", Values: 3, Top: []ValueCount{{Value: "a", Series: 1}}},
+		{Name: "job", Values: 2, Top: []ValueCount{{Value: "node", Series: 2}}},
+	}}
+	if !reflect.DeepEqual(dumps[1].Cardinality, expected) {
+		t.Errorf("series cardinality %+v, want %+v", dumps[1].Cardinality, expected)
+	}
+	if !reflect.DeepEqual(dumps[1].Labels, []string{"__name__", "instance", "job"}) {
+		t.Errorf("unexpected labels %v", dumps[1].Labels)
+	}
+
+	// the tsdb status only counts the series of its top metrics and no label values
+	cfg.Cardinality, cfg.TopValues = CardinalityTSDB, 0
+	dumps, err = MetricsWithLabels(context.Background(), server.URL, cfg, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dumps[0].Cardinality != nil {
+		t.Errorf("metric missing from the tsdb status got %+v", dumps[0].Cardinality)
+	}
+	if !reflect.DeepEqual(dumps[1].Cardinality, &Cardinality{Series: 42}) {
+		t.Errorf("tsdb cardinality %+v", dumps[1].Cardinality)
+	}
+}
+
+func TestMergeMetadata(t *testing.T) {
+	cases := []struct {
+		variants []v1.Metadata
+		expected MetricInfo
+	}{
+		{
+			[]v1.Metadata{{Type: v1.MetricTypeCounter, Help: "Requests.", Unit: "requests"}},
+			MetricInfo{Name: "m", Type: "counter", Unit: "requests", Help: "Requests.", Helps: []string{"Requests."}},
+		},
+		{
+			// identical variants of several targets are merged
+			[]v1.Metadata{{Type: v1.MetricTypeGauge, Help: "Up."}, {Type: v1.MetricTypeGauge, Help: "Up."}},
+			MetricInfo{Name: "m", Type: "gauge", Help: "Up.", Helps: []string{"Up."}},
+		},
+		{
+			[]v1.Metadata{
+				{Type: v1.MetricTypeGauge, Help: "Up."},
+				{Type: v1.MetricTypeGauge, Help: "Up, but older."},
+				{Type: v1.MetricTypeGauge, Help: "Up."},
+			},
+			MetricInfo{Name: "m", Type: "gauge", Help: "Up.", Helps: []string{"Up.", "Up, but older."}, Conflicts: []string{"help"}},
+		},
+		{
+			[]v1.Metadata{
+				{Type: v1.MetricTypeCounter, Help: "Bytes.", Unit: "bytes"},
+				{Type: v1.MetricTypeGauge, Help: "Bytes.", Unit: "kilobytes"},
+			},
+			MetricInfo{Name: "m", Type: "counter", Unit: "bytes", Help: "Bytes.", Helps: []string{"Bytes."}, Conflicts: []string{"type", "unit"}},
+		},
+	}
+	for _, c := range cases {
+		info := mergeMetadata("m", c.variants)
+		if !reflect.DeepEqual(info, c.expected) {
+			t.Errorf("merged %+v into %+v, want %+v", c.variants, info, c.expected)
+		}
+	}
+}
+
+func TestMetricsMatchSeriesOfHistograms(t *testing.T) {
+	metadata := map[string][]map[string]string{
+		"http_request_duration_seconds": {{"type": "histogram", "help": "Request durations.", "unit": ""}},
+		"rpc_duration_seconds":          {{"type": "summary", "help": "RPC durations.", "unit": ""}},
+		"process_cpu_seconds":           {{"type": "counter", "help": "CPU time.", "unit": "seconds"}},
+		"node_load1":                    {{"type": "gauge", "help": "Load.", "unit": ""}},
+		"up":                            {{"type": "gauge", "help": "Up.", "unit": ""}},
+	}
+	// the names of the series matching the selector
+	names := []string{
+		"http_request_duration_seconds_bucket", "http_request_duration_seconds_count",
+		"process_cpu_seconds_total", "node_load1_sum", "up",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data interface{}
+		switch r.URL.Path {
+		case "/api/v1/metadata":
+			data = metadata
+		case "/api/v1/label/__name__/values":
+			if r.URL.Query().Get("match[]") != `{job="api"}` {
+				http.Error(w, "unexpected selector", http.StatusBadRequest)
+				return
+			}
+			data = names
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "data": data})
+	}))
+	defer server.Close()
+	cfg := MetricsConfig{Start: time.Unix(0, 0), End: time.Unix(3600, 0), Matches: []string{`{job="api"}`}, NoLabels: true}
+	dumps, err := MetricsWithLabels(context.Background(), server.URL, cfg, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := make([]string, 0, len(dumps))
+	for _, dump := range dumps {
+		listed = append(listed, dump.Name)
+	}
+	// a gauge has no _sum series, so node_load1_sum belongs to another metric
+	expected := []string{"http_request_duration_seconds", "process_cpu_seconds", "up"}
+	if !reflect.DeepEqual(listed, expected) {
+		t.Errorf("listed %v, want %v", listed, expected)
+	}
+}
+
+// inFlight counts the concurrent label lookups passing through it.
+type inFlight struct {
+	mutex   sync.Mutex
+	current int
+	max     int
+}
+
+func (f *inFlight) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Path != "/api/v1/labels" {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	f.mutex.Lock()
+	f.current++
+	if f.current > f.max {
+		f.max = f.current
+	}
+	f.mutex.Unlock()
+	defer func() {
+		f.mutex.Lock()
+		f.current--
+		f.mutex.Unlock()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestMetricsAcrossSharesConcurrency(t *testing.T) {
+	series := make([]map[string]string, 0)
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		series = append(series, map[string]string{"__name__": name, "job": "node"})
+	}
+	targets := make([]Target, 0)
+	for _, alias := range []string{"eu", "us", "ap"} {
+		targets = append(targets, Target{Alias: alias, URL: fakeMetrics(t, series).URL})
+	}
+	counter := &inFlight{}
+	cfg := MetricsConfig{Start: time.Unix(0, 0), End: time.Unix(3600, 0), Concurrency: 2}
+	dumps, err := MetricsAcross(context.Background(), targets, cfg, &http.Client{Transport: counter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dumps) != 6 || len(dumps[0].Servers) != 3 {
+		t.Errorf("unexpected metrics %+v", dumps)
+	}
+	if counter.max > 2 {
+		t.Errorf("%d label lookups ran at once with a concurrency of 2", counter.max)
+	}
+}
+
+func TestForEachConcurrentlyReleasesSemaphoreOnCancel(t *testing.T) {
+	semaphore := newSemaphore(2)
+	// select picks randomly between a free slot and the cancelled context, so try it a few times
+	for run := 0; run < 50; run++ {
+		for _, cancelAt := range []int{-1, 3} {
+			ctx, cancel := context.WithCancel(context.Background())
+			if cancelAt < 0 {
+				cancel()
+			}
+			err := forEachConcurrently(ctx, 100, semaphore, func(ctx context.Context, i int) error {
+				if i == cancelAt {
+					cancel()
+				}
+				return nil
+			})
+			cancel()
+			if err != context.Canceled {
+				t.Fatalf("cancelled at %d: expected the cancellation, got %v", cancelAt, err)
+			}
+			if len(semaphore) != 0 {
+				t.Fatalf("cancelled at %d: %d slots of the semaphore are still held", cancelAt, len(semaphore))
+			}
+		}
+	}
+}
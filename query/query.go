@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/api"
@@ -32,19 +33,39 @@ type Timerange struct {
 	Step  time.Duration
 }
 
+// Query is a PromQL expression with a short alias used in manifests and file names.
+type Query struct {
+	Alias string
+	Expr  string
+}
+
+// Target is a prometheus url with a short alias used in manifests and file names.
+type Target struct {
+	Alias string
+	URL   string
+}
+
+// Result is the answer of a single prometheus to a single query.
+type Result struct {
+	Target   Target
+	Query    Query
+	Value    prommodel.Value
+	Warnings v1.Warnings
+}
+
 type QueryConfig struct {
 	Timerange
 	Query string
 }
 
-func Single(ctx context.Context, url string, query QueryConfig, httpClient *http.Client) (prommodel.Value, error) {
+func Single(ctx context.Context, url string, query QueryConfig, httpClient *http.Client) (prommodel.Value, v1.Warnings, error) {
 	cfg := api.Config{
 		Address: url,
 		Client:  httpClient,
 	}
 	client, err := api.NewClient(cfg)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	api := v1.NewAPI(client)
 	result, warns, err := api.QueryRange(ctx, query.Query, v1.Range{
@@ -53,7 +74,7 @@ func Single(ctx context.Context, url string, query QueryConfig, httpClient *http
 		Step:  query.Step,
 	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	for _, warn := range warns {
 		fmt.Fprintf(os.Stderr, "Prometheus API warning: %s\n", warn)
@@ -62,7 +83,7 @@ func Single(ctx context.Context, url string, query QueryConfig, httpClient *http
 	// where prometheus omits it.
 	matrix, ok := result.(prommodel.Matrix)
 	if !ok {
-		return result, fmt.Errorf("query result is not a matrix for: %s", query.Query)
+		return result, warns, fmt.Errorf("query result is not a matrix for: %s", query.Query)
 	}
 	for _, stream := range matrix {
 		_, hasName := stream.Metric[prommodel.MetricNameLabel]
@@ -70,64 +91,110 @@ func Single(ctx context.Context, url string, query QueryConfig, httpClient *http
 			stream.Metric[prommodel.MetricNameLabel] = prommodel.LabelValue(query.Query)
 		}
 	}
-	return result, nil
+	return result, warns, nil
 }
 
 type MultiQueryConfig struct {
 	Timerange
-	Queries []string
+	Queries []Query
 }
 
-func Multi(ctx context.Context, url string, query MultiQueryConfig, httpClient *http.Client) ([]prommodel.Value, error) {
-	results := make([]prommodel.Value, 0)
-	for _, queryStr := range query.Queries {
-		cfg := QueryConfig{Query: queryStr}
+func Multi(ctx context.Context, target Target, query MultiQueryConfig, httpClient *http.Client) ([]Result, error) {
+	results := make([]Result, 0)
+	for _, q := range query.Queries {
+		cfg := QueryConfig{Query: q.Expr}
 		cfg.Timerange = query.Timerange
-		result, err := Single(ctx, url, cfg, httpClient)
+		value, warns, err := Single(ctx, target.URL, cfg, httpClient)
 		if err != nil {
 			return nil, err
 		}
-		results = append(results, result)
+		results = append(results, Result{Target: target, Query: q, Value: value, Warnings: warns})
 	}
 	return results, nil
 }
 
 type ProductQueryConfig struct {
 	MultiQueryConfig
-	URLs []string
+	URLs []Target
 }
 
 type multiResult struct {
-	Values []prommodel.Value
-	Err    error
+	Index   int
+	Results []Result
+	Err     error
 }
 
-func Product(ctx context.Context, query ProductQueryConfig, httpClient *http.Client) ([]prommodel.Value, error) {
+// Product queries all urls concurrently. The results are ordered by url and query.
+func Product(ctx context.Context, query ProductQueryConfig, httpClient *http.Client) ([]Result, error) {
 	expected := len(query.URLs)
 	resultChan := make(chan multiResult)
 	for i := range query.URLs {
-		url := query.URLs[i]
+		index := i
 		go func() {
-			values, err := Multi(ctx, url, query.MultiQueryConfig, httpClient)
-			resultChan <- multiResult{Values: values, Err: err}
+			results, err := Multi(ctx, query.URLs[index], query.MultiQueryConfig, httpClient)
+			resultChan <- multiResult{Index: index, Results: results, Err: err}
 		}()
 	}
 	errs := make([]error, 0)
-	values := make([]prommodel.Value, 0)
-	counter := 0
-	for result := range resultChan {
+	perURL := make([][]Result, expected)
+	for counter := 0; counter < expected; counter++ {
+		result := <-resultChan
 		if result.Err != nil {
 			errs = append(errs, result.Err)
 		} else {
-			values = append(values, result.Values...)
-		}
-		counter++
-		if counter == expected {
-			close(resultChan)
+			perURL[result.Index] = result.Results
 		}
 	}
 	if len(errs) > 0 {
 		return nil, errors.Join(errs...)
 	}
-	return values, nil
+	results := make([]Result, 0)
+	for _, current := range perURL {
+		results = append(results, current...)
+	}
+	return results, nil
+}
+
+// Values returns the values of all results in order.
+func Values(results []Result) []prommodel.Value {
+	values := make([]prommodel.Value, 0, len(results))
+	for _, result := range results {
+		values = append(values, result.Value)
+	}
+	return values
+}
+
+func newAPI(url string, httpClient *http.Client) (v1.API, error) {
+	client, err := api.NewClient(api.Config{
+		Address: url,
+		Client:  httpClient,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v1.NewAPI(client), nil
+}
+
+// forEachTarget calls fn for all targets concurrently and joins the errors,
+// prefixed with the alias of their target.
+func forEachTarget(targets []Target, fn func(i int) error) error {
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i := range targets {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := fn(i); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", targets[i].Alias, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func printWarnings(warns v1.Warnings) {
+	for _, warn := range warns {
+		fmt.Fprintf(os.Stderr, "Prometheus API warning: %s\n", warn)
+	}
 }
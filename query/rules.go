@@ -0,0 +1,75 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"context"
+	"net/http"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// TargetRules are the rule groups loaded by a prometheus.
+type TargetRules struct {
+	Target Target
+	Groups []v1.RuleGroup
+}
+
+// TargetAlerts are the active alerts of a prometheus.
+type TargetAlerts struct {
+	Target Target
+	Alerts []v1.Alert
+}
+
+// Rules looks up the rule groups of all targets concurrently.
+func Rules(ctx context.Context, targets []Target, httpClient *http.Client) ([]TargetRules, error) {
+	result := make([]TargetRules, len(targets))
+	err := forEachTarget(targets, func(i int) error {
+		api, err := newAPI(targets[i].URL, httpClient)
+		if err != nil {
+			return err
+		}
+		rules, err := api.Rules(ctx)
+		if err != nil {
+			return err
+		}
+		result[i] = TargetRules{Target: targets[i], Groups: rules.Groups}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Alerts looks up the active alerts of all targets concurrently.
+func Alerts(ctx context.Context, targets []Target, httpClient *http.Client) ([]TargetAlerts, error) {
+	result := make([]TargetAlerts, len(targets))
+	err := forEachTarget(targets, func(i int) error {
+		api, err := newAPI(targets[i].URL, httpClient)
+		if err != nil {
+			return err
+		}
+		alerts, err := api.Alerts(ctx)
+		if err != nil {
+			return err
+		}
+		result[i] = TargetAlerts{Target: targets[i], Alerts: alerts.Alerts}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
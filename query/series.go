@@ -0,0 +1,63 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+)
+
+type SeriesConfig struct {
+	Matches []string
+	Start   time.Time
+	End     time.Time
+}
+
+// Series is the label set of a series matched on a prometheus.
+type Series struct {
+	Target Target
+	Labels prommodel.LabelSet
+}
+
+// MatchingSeries looks up the series matching cfg.Matches on all targets
+// concurrently. The results are ordered by target.
+func MatchingSeries(ctx context.Context, targets []Target, cfg SeriesConfig, httpClient *http.Client) ([]Series, error) {
+	perTarget := make([][]prommodel.LabelSet, len(targets))
+	err := forEachTarget(targets, func(i int) error {
+		api, err := newAPI(targets[i].URL, httpClient)
+		if err != nil {
+			return err
+		}
+		series, warns, err := api.Series(ctx, cfg.Matches, cfg.Start, cfg.End)
+		if err != nil {
+			return err
+		}
+		printWarnings(warns)
+		perTarget[i] = series
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Series, 0)
+	for i, series := range perTarget {
+		for _, labels := range series {
+			result = append(result, Series{Target: targets[i], Labels: labels})
+		}
+	}
+	return result, nil
+}
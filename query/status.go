@@ -0,0 +1,70 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// Status is the tsdb, build and runtime information of a prometheus with its flags.
+type Status struct {
+	Target      Target
+	TSDB        v1.TSDBResult
+	Buildinfo   v1.BuildinfoResult
+	Runtimeinfo v1.RuntimeinfoResult
+	Flags       v1.FlagsResult
+}
+
+// Statuses looks up the status endpoints of all targets concurrently. The runtime
+// info and flags are often disabled or not proxied, so they are left empty with a
+// warning if they cannot be fetched.
+func Statuses(ctx context.Context, targets []Target, httpClient *http.Client) ([]Status, error) {
+	result := make([]Status, len(targets))
+	err := forEachTarget(targets, func(i int) error {
+		api, err := newAPI(targets[i].URL, httpClient)
+		if err != nil {
+			return err
+		}
+		status := Status{Target: targets[i]}
+		if status.TSDB, err = api.TSDB(ctx); err != nil {
+			return fmt.Errorf("failed to get the tsdb status: %w", err)
+		}
+		if status.Buildinfo, err = api.Buildinfo(ctx); err != nil {
+			return fmt.Errorf("failed to get the build info: %w", err)
+		}
+		if status.Runtimeinfo, err = api.Runtimeinfo(ctx); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "warning: %s: failed to get the runtime info, leaving start time and retention empty: %s\n", targets[i].Alias, err)
+		}
+		if status.Flags, err = api.Flags(ctx); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "warning: %s: failed to get the flags, leaving the retention size empty: %s\n", targets[i].Alias, err)
+		}
+		result[i] = status
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusesWithoutRuntimeinfoAndFlags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/status/tsdb":
+			fmt.Fprint(w, `{"status":"success","data":{"headStats":{"numSeries":3,"minTime":1696154400000,"maxTime":1696161600000},
+				"seriesCountByMetricName":[],"labelValueCountByLabelName":[],"memoryInBytesByLabelName":[],"seriesCountByLabelValuePair":[]}}`)
+		case "/api/v1/status/buildinfo":
+			fmt.Fprint(w, `{"status":"success","data":{"version":"2.47.2","revision":"abc","branch":"","buildUser":"","buildDate":"","goVersion":"go1.21"}}`)
+		default:
+			http.Error(w, "forbidden by the proxy", http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+	statuses, err := Statuses(context.Background(), []Target{{Alias: "eu", URL: server.URL}}, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 {
+		t.Fatalf("expected one status, got %d", len(statuses))
+	}
+	current := statuses[0]
+	if current.Target.Alias != "eu" || current.Buildinfo.Version != "2.47.2" || current.TSDB.HeadStats.NumSeries != 3 {
+		t.Errorf("unexpected status %+v", current)
+	}
+	if !current.Runtimeinfo.StartTime.IsZero() || current.Runtimeinfo.StorageRetention != "" || len(current.Flags) != 0 {
+		t.Errorf("expected empty runtime info and flags, got %+v and %v", current.Runtimeinfo, current.Flags)
+	}
+}
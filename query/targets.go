@@ -0,0 +1,54 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"context"
+	"net/http"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// ScrapeTargets are the scrape targets of a prometheus. Metadata is only
+// set if it was looked up.
+type ScrapeTargets struct {
+	Target   Target
+	Targets  v1.TargetsResult
+	Metadata []v1.MetricMetadata
+}
+
+// Targets looks up the scrape targets and optionally the metric metadata of
+// all targets concurrently.
+func Targets(ctx context.Context, targets []Target, withMetadata bool, httpClient *http.Client) ([]ScrapeTargets, error) {
+	result := make([]ScrapeTargets, len(targets))
+	err := forEachTarget(targets, func(i int) error {
+		api, err := newAPI(targets[i].URL, httpClient)
+		if err != nil {
+			return err
+		}
+		scrapeTargets, err := api.Targets(ctx)
+		if err != nil {
+			return err
+		}
+		result[i] = ScrapeTargets{Target: targets[i], Targets: scrapeTargets}
+		if withMetadata {
+			result[i].Metadata, err = api.TargetsMetadata(ctx, "", "", "")
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
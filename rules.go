@@ -0,0 +1,102 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	prommodel "github.com/prometheus/common/model"
+	"github.com/sapcc/promdump/model"
+	"github.com/sapcc/promdump/query"
+)
+
+// rules writes one row per rule with the group it belongs to.
+func rules(ctx context.Context, w io.Writer, cfg catalogConfig) error {
+	out, httpClient, err := cfg.open(w, "rules")
+	if err != nil {
+		return err
+	}
+	defer out.abort()
+	perTarget, err := query.Rules(ctx, cfg.promURLs, httpClient)
+	if err != nil {
+		return err
+	}
+	table := model.NewTable(cfg.columns("group", "file", "interval", "type", "rule", "expr", "labels", "health", "last_error",
+		"evaluation_time", "last_evaluation", "state")...)
+	for _, current := range perTarget {
+		for _, group := range current.Groups {
+			for _, rule := range group.Rules {
+				row := map[string]interface{}{
+					"server":   current.Target.Alias,
+					"group":    group.Name,
+					"file":     group.File,
+					"interval": group.Interval,
+				}
+				switch typed := rule.(type) {
+				case v1.RecordingRule:
+					row["type"] = string(v1.RuleTypeRecording)
+					row["rule"] = typed.Name
+					row["expr"] = typed.Query
+					row["labels"] = labelMap(typed.Labels)
+					row["health"] = string(typed.Health)
+					row["last_error"] = typed.LastError
+					row["evaluation_time"] = typed.EvaluationTime
+					row["last_evaluation"] = formatTime(typed.LastEvaluation)
+				case v1.AlertingRule:
+					row["type"] = string(v1.RuleTypeAlerting)
+					row["rule"] = typed.Name
+					row["expr"] = typed.Query
+					row["labels"] = labelMap(typed.Labels)
+					row["health"] = string(typed.Health)
+					row["last_error"] = typed.LastError
+					row["evaluation_time"] = typed.EvaluationTime
+					row["last_evaluation"] = formatTime(typed.LastEvaluation)
+					row["state"] = typed.State
+				}
+				table.Add(row)
+			}
+		}
+	}
+	return out.write(table, cfg.promURLs)
+}
+
+// alerts writes one row per active alert.
+func alerts(ctx context.Context, w io.Writer, cfg catalogConfig) error {
+	out, httpClient, err := cfg.open(w, "alerts")
+	if err != nil {
+		return err
+	}
+	defer out.abort()
+	perTarget, err := query.Alerts(ctx, cfg.promURLs, httpClient)
+	if err != nil {
+		return err
+	}
+	table := model.NewTable(cfg.columns("alertname", "state", "active_at", "value", "labels", "annotations")...)
+	for _, current := range perTarget {
+		for _, alert := range current.Alerts {
+			table.Add(map[string]interface{}{
+				"server":      current.Target.Alias,
+				"alertname":   string(alert.Labels[prommodel.AlertNameLabel]),
+				"state":       string(alert.State),
+				"active_at":   formatTime(alert.ActiveAt),
+				"value":       alert.Value,
+				"labels":      labelMap(alert.Labels),
+				"annotations": labelMap(alert.Annotations),
+			})
+		}
+	}
+	return out.write(table, cfg.promURLs)
+}
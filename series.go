@@ -0,0 +1,153 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	prommodel "github.com/prometheus/common/model"
+	"github.com/sapcc/promdump/client"
+	"github.com/sapcc/promdump/model"
+	"github.com/sapcc/promdump/query"
+)
+
+type seriesConfig struct {
+	http     client.HTTPConfig
+	output   outputConfig
+	promURLs []query.Target
+	lookup   query.SeriesConfig
+	table    bool
+}
+
+// series writes one row per matched series and reports the counts to stderr.
+func series(ctx context.Context, w io.Writer, cfg seriesConfig) error {
+	layout := model.Layout(cfg.output.layout)
+	if layout != model.LayoutFlat && layout != model.LayoutNested {
+		return fmt.Errorf("series only support the flat and nested layouts")
+	}
+	httpClient, err := client.MakeHTTPClient(cfg.http)
+	if err != nil {
+		return err
+	}
+	out, err := cfg.output.openCatalog(w, cfg.table)
+	if err != nil {
+		return err
+	}
+	defer out.abort()
+	matched, err := query.MatchingSeries(ctx, cfg.promURLs, cfg.lookup, &httpClient)
+	if err != nil {
+		return err
+	}
+	var table *model.Table
+	if layout == model.LayoutNested {
+		table = cfg.nestedTable(matched)
+	} else {
+		table = cfg.flatTable(matched)
+	}
+	if err := out.write(table, cfg.promURLs); err != nil {
+		return err
+	}
+	counts := make(map[string]int)
+	for _, current := range matched {
+		counts[current.Target.Alias]++
+	}
+	perServer := make([]string, 0, len(cfg.promURLs))
+	for _, target := range cfg.promURLs {
+		perServer = append(perServer, fmt.Sprintf("%s: %d", target.Alias, counts[target.Alias]))
+	}
+	fmt.Fprintf(os.Stderr, "matched %d series (%s)\n", len(matched), strings.Join(perServer, ", "))
+	return nil
+}
+
+func (cfg *seriesConfig) columns() []string {
+	if len(cfg.promURLs) > 1 {
+		return []string{"server", "metric"}
+	}
+	return []string{"metric"}
+}
+
+// flatTable has a column per label name, like the flat layout of dumps.
+// Label names colliding with the server or metric column are prefixed with label_.
+func (cfg *seriesConfig) flatTable(matched []query.Series) *model.Table {
+	names := make(map[string]struct{})
+	for _, current := range matched {
+		for name := range current.Labels {
+			if name != prommodel.MetricNameLabel {
+				names[string(name)] = struct{}{}
+			}
+		}
+	}
+	labelNames := make([]string, 0, len(names))
+	for name := range names {
+		labelNames = append(labelNames, name)
+	}
+	sort.Strings(labelNames)
+	columns := cfg.columns()
+	reserved := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		reserved[column] = true
+	}
+	taken := make(map[string]bool, len(labelNames))
+	for _, name := range labelNames {
+		taken[name] = true
+	}
+	labelColumns := make(map[string]string, len(labelNames))
+	for _, name := range labelNames {
+		column := name
+		for reserved[name] && taken[column] {
+			column = "label_" + column
+		}
+		taken[column] = true
+		labelColumns[name] = column
+		columns = append(columns, column)
+	}
+	table := model.NewTable(columns...)
+	for _, current := range matched {
+		row := map[string]interface{}{
+			"server": current.Target.Alias,
+			"metric": string(current.Labels[prommodel.MetricNameLabel]),
+		}
+		for name, value := range current.Labels {
+			if name != prommodel.MetricNameLabel {
+				row[labelColumns[string(name)]] = string(value)
+			}
+		}
+		table.Add(row)
+	}
+	return table
+}
+
+// nestedTable keeps the labels of each series in a single labels column.
+func (cfg *seriesConfig) nestedTable(matched []query.Series) *model.Table {
+	table := model.NewTable(append(cfg.columns(), "labels")...)
+	for _, current := range matched {
+		labels := make(map[string]string, len(current.Labels))
+		for name, value := range current.Labels {
+			if name != prommodel.MetricNameLabel {
+				labels[string(name)] = string(value)
+			}
+		}
+		table.Add(map[string]interface{}{
+			"server": current.Target.Alias,
+			"metric": string(current.Labels[prommodel.MetricNameLabel]),
+			"labels": labels,
+		})
+	}
+	return table
+}
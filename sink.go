@@ -0,0 +1,274 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	prommodel "github.com/prometheus/common/model"
+	"github.com/sapcc/promdump/compressor"
+	"github.com/sapcc/promdump/manifest"
+	"github.com/sapcc/promdump/model"
+	"github.com/sapcc/promdump/output"
+	"github.com/sapcc/promdump/query"
+)
+
+// sink writes values to a single file, a partitioned directory or a tar
+// bundle. It is opened before any data is fetched, so existing outputs are
+// refused early.
+type sink struct {
+	cfg          outputConfig
+	manifestMode manifest.Mode
+	file         *output.Compressed
+	dir          *output.Dir
+	compression  compressor.Compression
+}
+
+func (cfg *outputConfig) openSink() (*sink, error) {
+	if cfg.bundle != "" && cfg.bundle != bundleQuery && cfg.bundle != bundleQueryURL {
+		return nil, fmt.Errorf("unknown bundle mode: %s", cfg.bundle)
+	}
+	if cfg.partitioned() && cfg.bundle != "" {
+		return nil, fmt.Errorf("--bundle and --partition-by cannot be combined")
+	}
+	s := &sink{cfg: *cfg}
+	var err error
+	if cfg.partitioned() {
+		if cfg.toStdout() {
+			return nil, fmt.Errorf("--partition-by needs a directory as --output")
+		}
+		if model.Layout(cfg.layout) != model.LayoutFlat {
+			return nil, fmt.Errorf("--partition-by only supports the flat layout")
+		}
+		s.compression, _, err = compressor.ParseCompression(cfg.compression)
+		if err != nil {
+			return nil, err
+		}
+		if model.Format(cfg.format) == model.FormatParquet && s.compression != compressor.CompressionNone {
+			return nil, fmt.Errorf("parquet parts cannot be compressed as a whole")
+		}
+	}
+	s.manifestMode, err = cfg.manifestMode()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.partitioned() {
+		s.dir, err = output.CreateDir(cfg.path, cfg.force, manifest.DirectoryFile)
+	} else {
+		s.file, err = cfg.open()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sink) abort() {
+	if s.dir != nil {
+		s.dir.Abort()
+	}
+	if s.file != nil {
+		s.file.Abort()
+	}
+}
+
+// write marshals values, commits the output and writes the manifest described by m.
+func (s *sink) write(values []prommodel.Value, m manifest.Manifest) error {
+	if s.cfg.bundle != "" {
+		return fmt.Errorf("--bundle needs one result per query")
+	}
+	metadata, err := s.metadata(m)
+	if err != nil {
+		return err
+	}
+	if s.dir != nil {
+		return s.writePartitioned(values, m, metadata)
+	}
+	if err := model.WriteSlice(s.file, values, model.Layout(s.cfg.layout), model.Format(s.cfg.format), metadata); err != nil {
+		return err
+	}
+	return s.commit(m)
+}
+
+// writeTable writes catalog data opened with openCatalog.
+func (s *sink) writeTable(table *model.Table, m manifest.Manifest) error {
+	m.Rows = len(table.Rows)
+	metadata, err := s.metadata(m)
+	if err != nil {
+		return err
+	}
+	if err := model.WriteTable(s.file, table, model.Format(s.cfg.format), metadata); err != nil {
+		return err
+	}
+	return s.commit(m)
+}
+
+// commit writes the manifest next to the single output file and commits it.
+// The sidecar goes first and is removed again if the commit fails, so there
+// is never a data file without its manifest.
+func (s *sink) commit(m manifest.Manifest) error {
+	if s.manifestMode != manifest.ModeSidecar {
+		return s.file.Commit()
+	}
+	if err := s.file.Flush(); err != nil {
+		return err
+	}
+	m.SHA256 = s.file.SHA256()
+	if err := m.WriteSidecar(s.cfg.path, s.cfg.force); err != nil {
+		return err
+	}
+	if err := s.file.Commit(); err != nil {
+		os.Remove(manifest.SidecarPath(s.cfg.path))
+		return err
+	}
+	return nil
+}
+
+// writeResults is write for the results of queries, it supports bundles.
+func (s *sink) writeResults(results []query.Result, queries []query.Query, m manifest.Manifest) error {
+	if s.cfg.bundle != "" {
+		return s.writeBundle(results, queries, m)
+	}
+	return s.write(query.Values(results), m)
+}
+
+func (s *sink) metadata(m manifest.Manifest) (map[string]string, error) {
+	if s.manifestMode != manifest.ModeEmbed {
+		return nil, nil
+	}
+	return m.Metadata()
+}
+
+// writeBundle writes a tar archive with one file per query or per query and
+// url and the manifest as last entry.
+func (s *sink) writeBundle(results []query.Result, queries []query.Query, m manifest.Manifest) error {
+	metadata, err := s.metadata(m)
+	if err != nil {
+		return err
+	}
+	// results are ordered by url, entries are ordered by query and then url
+	names := make([]string, 0)
+	entries := make(map[string][]query.Result)
+	for _, result := range results {
+		name := result.Query.Alias
+		if s.cfg.bundle == bundleQueryURL {
+			name += "/" + result.Target.Alias
+		}
+		if _, ok := entries[name]; !ok {
+			names = append(names, name)
+		}
+		entries[name] = append(entries[name], result)
+	}
+	queryIndex := make(map[string]int)
+	for i, q := range queries {
+		queryIndex[q.Alias] = i
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return queryIndex[entries[names[i]][0].Query.Alias] < queryIndex[entries[names[j]][0].Query.Alias]
+	})
+	bundle := output.NewBundle(s.file)
+	files := make([]manifest.File, 0, len(names))
+	for _, name := range names {
+		path := name + "." + s.cfg.format
+		digest := sha256.New()
+		err := bundle.AddFrom(path, func(w io.Writer) error {
+			return model.WriteSlice(io.MultiWriter(w, digest), query.Values(entries[name]), model.Layout(s.cfg.layout), model.Format(s.cfg.format), metadata)
+		})
+		if err != nil {
+			return err
+		}
+		counts := manifest.Manifest{}
+		for _, result := range entries[name] {
+			counts.AddCounts(result.Value)
+		}
+		files = append(files, manifest.File{Path: path, Rows: counts.Rows, SHA256: hex.EncodeToString(digest.Sum(nil))})
+	}
+	if s.manifestMode == manifest.ModeSidecar {
+		m.Files = files
+		marshaled, err := m.JSON()
+		if err != nil {
+			return err
+		}
+		if err := bundle.Add(manifest.BundleFile, marshaled); err != nil {
+			return err
+		}
+	}
+	if err := bundle.Close(); err != nil {
+		return err
+	}
+	return s.file.Commit()
+}
+
+// writePartitioned writes a hive style directory of part files, csv and
+// ndjson parts are compressed one by one.
+func (s *sink) writePartitioned(values []prommodel.Value, m manifest.Manifest, metadata map[string]string) error {
+	partitionConfig := model.PartitionConfig{
+		Keys:           s.cfg.partitionBy,
+		MaxRowsPerFile: s.cfg.maxRows,
+		Format:         model.Format(s.cfg.format),
+		Metadata:       metadata,
+	}
+	files := make([]manifest.File, 0)
+	err := model.MarshalPartitioned(values, partitionConfig, func(part model.Part) error {
+		name := part.Path + output.Extension(s.compression)
+		file, err := s.dir.Create(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		digest := sha256.New()
+		writer, err := compressor.NewWriter(io.MultiWriter(file, digest), s.cfg.compression)
+		if err != nil {
+			return err
+		}
+		if err := part.Write(writer); err != nil {
+			return err
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+		files = append(files, manifest.File{Path: name, Rows: part.Rows, SHA256: hex.EncodeToString(digest.Sum(nil))})
+		return file.Close()
+	})
+	if err != nil {
+		return err
+	}
+	// the manifest marks the directory as written by promdump, so --force can
+	// replace it later, without a sidecar manifest it is left empty
+	var marshaled []byte
+	if s.manifestMode == manifest.ModeSidecar {
+		m.Files = files
+		marshaled, err = m.JSON()
+		if err != nil {
+			return err
+		}
+	}
+	file, err := s.dir.Create(manifest.DirectoryFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(marshaled); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return s.dir.Commit()
+}
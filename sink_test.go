@@ -0,0 +1,195 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	prommodel "github.com/prometheus/common/model"
+	"github.com/sapcc/promdump/manifest"
+	"github.com/sapcc/promdump/query"
+)
+
+func testValues() []prommodel.Value {
+	return []prommodel.Value{prommodel.Matrix{
+		&prommodel.SampleStream{
+			Metric: prommodel.Metric{prommodel.MetricNameLabel: "up", "job": "node"},
+			Values: []prommodel.SamplePair{{Timestamp: 1696154400000, Value: 1}, {Timestamp: 1696154460000, Value: 0}},
+		},
+	}}
+}
+
+func TestSinkWritesSidecar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.csv.gz")
+	cfg := outputConfig{path: path, format: "csv", layout: "flat", compression: "gzip", manifest: manifest.ModeAuto}
+	s, err := cfg.openSink()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.write(testValues(), manifest.Manifest{}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sidecar, err := os.ReadFile(manifest.SidecarPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m manifest.Manifest
+	if err := json.Unmarshal(sidecar, &m); err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(data)
+	if m.SHA256 != hex.EncodeToString(digest[:]) {
+		t.Errorf("sidecar checksum %s does not match the data file", m.SHA256)
+	}
+}
+
+func TestSinkCommitsDataAndSidecarTogether(t *testing.T) {
+	for _, existing := range []string{"data", "sidecar"} {
+		path := filepath.Join(t.TempDir(), "dump.csv")
+		cfg := outputConfig{path: path, format: "csv", layout: "flat", compression: "none", manifest: manifest.ModeAuto}
+		s, err := cfg.openSink()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// another process creates one of the outputs while the dump is running
+		other := path
+		if existing == "sidecar" {
+			other = manifest.SidecarPath(path)
+		}
+		if err := os.WriteFile(other, []byte("other"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.write(testValues(), manifest.Manifest{}); err == nil {
+			t.Fatalf("existing %s: expected the commit to fail", existing)
+		}
+		s.abort()
+		for _, p := range []string{path, manifest.SidecarPath(path)} {
+			data, err := os.ReadFile(p)
+			if p == other {
+				if string(data) != "other" {
+					t.Errorf("existing %s: %s was overwritten with %q", existing, p, data)
+				}
+			} else if !os.IsNotExist(err) {
+				t.Errorf("existing %s: expected %s to be absent, got %v", existing, p, err)
+			}
+		}
+	}
+}
+
+func TestSinkPartitionedCanBeReplaced(t *testing.T) {
+	for _, mode := range []manifest.Mode{manifest.ModeAuto, manifest.ModeNone, manifest.ModeEmbed} {
+		path := filepath.Join(t.TempDir(), "dataset")
+		for _, force := range []bool{false, true} {
+			cfg := outputConfig{path: path, force: force, format: "parquet", layout: "flat", compression: "none", manifest: mode, partitionBy: []string{"metric"}}
+			s, err := cfg.openSink()
+			if err != nil {
+				t.Fatalf("%s, force %t: %s", mode, force, err)
+			}
+			if err := s.write(testValues(), manifest.Manifest{}); err != nil {
+				t.Fatalf("%s, force %t: %s", mode, force, err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(path, "metric=up", "part-0.parquet")); err != nil {
+			t.Errorf("%s: %s", mode, err)
+		}
+		marker, err := os.ReadFile(filepath.Join(path, manifest.DirectoryFile))
+		if err != nil {
+			t.Fatalf("%s: %s", mode, err)
+		}
+		if (len(marker) > 0) != (mode == manifest.ModeAuto) {
+			t.Errorf("%s: unexpected manifest %q", mode, marker)
+		}
+	}
+}
+
+func TestSinkBundleOrdersEntriesByQuery(t *testing.T) {
+	queries := []query.Query{{Alias: "up", Expr: "up"}, {Alias: "load", Expr: "node_load1"}}
+	targets := []query.Target{{Alias: "a", URL: "http://a"}, {Alias: "b", URL: "http://b"}}
+	// results are ordered by url like query.Product returns them
+	results := make([]query.Result, 0)
+	for _, target := range targets {
+		for _, q := range queries {
+			results = append(results, query.Result{Target: target, Query: q, Value: testValues()[0]})
+		}
+	}
+	cases := map[string][]string{
+		bundleQuery:    {"up.json", "load.json", manifest.BundleFile},
+		bundleQueryURL: {"up/a.json", "up/b.json", "load/a.json", "load/b.json", manifest.BundleFile},
+	}
+	for mode, expected := range cases {
+		path := filepath.Join(t.TempDir(), "dump.tar")
+		cfg := outputConfig{path: path, format: "json", layout: "flat", compression: "none", manifest: manifest.ModeAuto, bundle: mode}
+		s, err := cfg.openSink()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.writeResults(results, queries, manifest.Manifest{}); err != nil {
+			t.Fatalf("%s: %s", mode, err)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		reader := tar.NewReader(file)
+		names := make([]string, 0)
+		var m manifest.Manifest
+		for {
+			header, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %s", mode, err)
+			}
+			names = append(names, header.Name)
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Name == manifest.BundleFile {
+				if err := json.Unmarshal(data, &m); err != nil {
+					t.Fatalf("%s: %s", mode, err)
+				}
+			} else if len(data) == 0 {
+				t.Errorf("%s: %s is empty", mode, header.Name)
+			}
+		}
+		if strings.Join(names, " ") != strings.Join(expected, " ") {
+			t.Errorf("%s: bundle has entries %v, want %v", mode, names, expected)
+		}
+		if len(m.Files) != len(expected)-1 {
+			t.Errorf("%s: manifest lists %d files, want %d", mode, len(m.Files), len(expected)-1)
+		}
+	}
+}
+
+func TestSinkBundleRejectsPartitions(t *testing.T) {
+	cfg := outputConfig{path: filepath.Join(t.TempDir(), "dump.tar"), format: "parquet", layout: "flat", compression: "none", manifest: manifest.ModeAuto, bundle: bundleQuery, partitionBy: []string{"metric"}}
+	if _, err := cfg.openSink(); err == nil {
+		t.Error("expected --bundle with --partition-by to be rejected")
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/sapcc/promdump/model"
+	"github.com/sapcc/promdump/query"
+)
+
+type statusConfig struct {
+	catalogConfig
+	// top limits the metrics and labels listed per prometheus, the tsdb status only reports the top 10
+	top int
+}
+
+// status writes one row per prometheus with its version, retention and head statistics.
+func status(ctx context.Context, w io.Writer, cfg statusConfig) error {
+	if cfg.top < 0 {
+		return fmt.Errorf("--top must not be negative")
+	}
+	out, httpClient, err := cfg.open(w, "status")
+	if err != nil {
+		return err
+	}
+	defer out.abort()
+	statuses, err := query.Statuses(ctx, cfg.promURLs, httpClient)
+	if err != nil {
+		return err
+	}
+	table := model.NewTable(cfg.columns("version", "revision", "go_version", "start_time", "retention", "retention_size",
+		"head_series", "head_chunks", "head_label_pairs", "head_min_time", "head_max_time", "top_metrics", "top_labels")...)
+	for _, current := range statuses {
+		table.Add(statusRow(current, cfg.top))
+	}
+	return out.write(table, cfg.promURLs)
+}
+
+// statusRow leaves the columns empty which a prometheus did not report.
+func statusRow(current query.Status, top int) map[string]interface{} {
+	head := current.TSDB.HeadStats
+	row := map[string]interface{}{
+		"server":           current.Target.Alias,
+		"version":          current.Buildinfo.Version,
+		"revision":         current.Buildinfo.Revision,
+		"go_version":       current.Buildinfo.GoVersion,
+		"start_time":       formatTime(current.Runtimeinfo.StartTime),
+		"retention":        current.Runtimeinfo.StorageRetention,
+		"retention_size":   current.Flags["storage.tsdb.retention.size"],
+		"head_series":      int64(head.NumSeries),
+		"head_chunks":      int64(head.ChunkCount),
+		"head_label_pairs": int64(head.NumLabelPairs),
+		"top_metrics":      topStats(current.TSDB.SeriesCountByMetricName, top),
+		"top_labels":       topStats(current.TSDB.LabelValueCountByLabelName, top),
+	}
+	if row["retention"] == "" {
+		// older prometheis do not report the retention in the runtime info
+		row["retention"] = current.Flags["storage.tsdb.retention.time"]
+	}
+	// an empty head reports math.MaxInt64 and math.MinInt64 as bounds
+	if head.NumSeries > 0 {
+		row["head_min_time"] = formatTime(time.UnixMilli(int64(head.MinTime)))
+		row["head_max_time"] = formatTime(time.UnixMilli(int64(head.MaxTime)))
+	}
+	return row
+}
+
+// topStats formats the first n stats as name=value list, which fits in a single column of every format.
+func topStats(stats []v1.Stat, n int) string {
+	if len(stats) > n {
+		stats = stats[:n]
+	}
+	entries := make([]string, len(stats))
+	for i, stat := range stats {
+		entries[i] = fmt.Sprintf("%s=%d", stat.Name, stat.Value)
+	}
+	return strings.Join(entries, ", ")
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// Eval evaluates expr as range query, or as instant query at start if step
+// is 0. Results are converted to a matrix, so they fit into dumps. Like
+// dumped queries, series without a metric name are named after expr.
+func (s *Store) Eval(ctx context.Context, engine *promql.Engine, expr string, start, end time.Time, step time.Duration) (prommodel.Matrix, storage.Warnings, error) {
+	var q promql.Query
+	var err error
+	if step == 0 {
+		q, err = engine.NewInstantQuery(ctx, s, nil, expr, start)
+	} else {
+		q, err = engine.NewRangeQuery(ctx, s, nil, expr, start, end, step)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer q.Close()
+	res := q.Exec(ctx)
+	if res.Err != nil {
+		return nil, res.Warnings, res.Err
+	}
+	matrix, err := toMatrix(res.Value, expr)
+	return matrix, res.Warnings, err
+}
+
+func toMatrix(value parser.Value, expr string) (prommodel.Matrix, error) {
+	matrix := make(prommodel.Matrix, 0)
+	switch typed := value.(type) {
+	case promql.Matrix:
+		for _, series := range typed {
+			stream := &prommodel.SampleStream{Metric: toMetric(series.Metric)}
+			for _, point := range series.Floats {
+				stream.Values = append(stream.Values, prommodel.SamplePair{Timestamp: prommodel.Time(point.T), Value: prommodel.SampleValue(point.F)})
+			}
+			matrix = append(matrix, stream)
+		}
+	case promql.Vector:
+		for _, sample := range typed {
+			matrix = append(matrix, &prommodel.SampleStream{
+				Metric: toMetric(sample.Metric),
+				Values: []prommodel.SamplePair{{Timestamp: prommodel.Time(sample.T), Value: prommodel.SampleValue(sample.F)}},
+			})
+		}
+	case promql.Scalar:
+		matrix = append(matrix, &prommodel.SampleStream{
+			Metric: prommodel.Metric{},
+			Values: []prommodel.SamplePair{{Timestamp: prommodel.Time(typed.T), Value: prommodel.SampleValue(typed.V)}},
+		})
+	default:
+		return nil, fmt.Errorf("cannot dump results of type %s", value.Type())
+	}
+	for _, stream := range matrix {
+		if _, hasName := stream.Metric[prommodel.MetricNameLabel]; !hasName {
+			stream.Metric[prommodel.MetricNameLabel] = prommodel.LabelValue(expr)
+		}
+	}
+	return matrix, nil
+}
+
+func toMetric(lset labels.Labels) prommodel.Metric {
+	metric := make(prommodel.Metric, lset.Len())
+	lset.Range(func(l labels.Label) {
+		metric[prommodel.LabelName(l.Name)] = prommodel.LabelValue(l.Value)
+	})
+	return metric
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+)
+
+func TestEvalNamesResults(t *testing.T) {
+	s := New()
+	s.Add(prommodel.Matrix{stream("up", 0, 1, 60000, 0)})
+	s.Sort()
+	engine := NewEngine(time.Minute)
+	cases := []struct {
+		expr     string
+		step     time.Duration
+		expected prommodel.LabelValue
+	}{
+		{"up", time.Minute, "up"},
+		{"up", 0, "up"},
+		{"sum(up)", time.Minute, "sum(up)"},
+		{"sum(up)", 0, "sum(up)"},
+		{"1 + 1", 0, "1 + 1"},
+	}
+	for _, c := range cases {
+		matrix, _, err := s.Eval(context.Background(), engine, c.expr, time.UnixMilli(0), time.UnixMilli(60000), c.step)
+		if err != nil {
+			t.Fatalf("%s: %s", c.expr, err)
+		}
+		if len(matrix) != 1 {
+			t.Fatalf("%s: expected one series, got %v", c.expr, matrix)
+		}
+		if name := matrix[0].Metric[prommodel.MetricNameLabel]; name != c.expected {
+			t.Errorf("%s with step %s: named %q, want %q", c.expr, c.step, name, c.expected)
+		}
+	}
+}
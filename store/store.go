@@ -0,0 +1,247 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+)
+
+// Store keeps dumped series in memory and makes them queryable by the
+// PromQL engine. It is not safe to Add while querying, Sort has to be called
+// after the last Add.
+type Store struct {
+	series map[string]*series
+	sorted []*series
+	minT   int64
+	maxT   int64
+}
+
+type series struct {
+	labels  labels.Labels
+	samples []chunks.Sample
+}
+
+type sample struct {
+	t int64
+	f float64
+}
+
+func (s sample) T() int64                      { return s.t }
+func (s sample) F() float64                    { return s.f }
+func (s sample) H() *histogram.Histogram       { return nil }
+func (s sample) FH() *histogram.FloatHistogram { return nil }
+func (s sample) Type() chunkenc.ValueType      { return chunkenc.ValFloat }
+
+func New() *Store {
+	return &Store{series: make(map[string]*series), minT: math.MaxInt64, maxT: math.MinInt64}
+}
+
+// Add merges matrix into the store, see Sort.
+func (s *Store) Add(matrix prommodel.Matrix) {
+	for _, stream := range matrix {
+		lset := make(map[string]string, len(stream.Metric))
+		for name, value := range stream.Metric {
+			lset[string(name)] = string(value)
+		}
+		ls := labels.FromMap(lset)
+		key := ls.String()
+		current, ok := s.series[key]
+		if !ok {
+			current = &series{labels: ls}
+			s.series[key] = current
+		}
+		for _, pair := range stream.Values {
+			current.samples = append(current.samples, sample{t: int64(pair.Timestamp), f: float64(pair.Value)})
+			if int64(pair.Timestamp) < s.minT {
+				s.minT = int64(pair.Timestamp)
+			}
+			if int64(pair.Timestamp) > s.maxT {
+				s.maxT = int64(pair.Timestamp)
+			}
+		}
+	}
+}
+
+// Sort orders the series and their samples once all dumps are added.
+// Samples of the same series with the same timestamp are kept once, the
+// last one added wins.
+func (s *Store) Sort() {
+	s.sorted = make([]*series, 0, len(s.series))
+	for _, current := range s.series {
+		sort.SliceStable(current.samples, func(i, j int) bool { return current.samples[i].T() < current.samples[j].T() })
+		deduplicated := current.samples[:0]
+		for i, smpl := range current.samples {
+			if i+1 < len(current.samples) && current.samples[i+1].T() == smpl.T() {
+				continue
+			}
+			deduplicated = append(deduplicated, smpl)
+		}
+		current.samples = deduplicated
+		s.sorted = append(s.sorted, current)
+	}
+	sort.Slice(s.sorted, func(i, j int) bool { return labels.Compare(s.sorted[i].labels, s.sorted[j].labels) < 0 })
+}
+
+// Series returns the number of series in the store.
+func (s *Store) Series() int {
+	return len(s.sorted)
+}
+
+// Timerange returns the timestamps of the oldest and newest sample.
+func (s *Store) Timerange() (time.Time, time.Time) {
+	if len(s.sorted) == 0 {
+		return time.Time{}, time.Time{}
+	}
+	return time.UnixMilli(s.minT).UTC(), time.UnixMilli(s.maxT).UTC()
+}
+
+// MetricNames returns the sorted names of all metrics.
+func (s *Store) MetricNames() []string {
+	seen := make(map[string]struct{})
+	names := make([]string, 0)
+	for _, current := range s.sorted {
+		name := current.labels.Get(labels.MetricName)
+		if _, ok := seen[name]; !ok && name != "" {
+			seen[name] = struct{}{}
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewEngine returns a PromQL engine suited for querying a Store.
+func NewEngine(timeout time.Duration) *promql.Engine {
+	return promql.NewEngine(promql.EngineOpts{
+		MaxSamples:           50000000,
+		Timeout:              timeout,
+		EnableAtModifier:     true,
+		EnableNegativeOffset: true,
+	})
+}
+
+func (s *Store) Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+	return &querier{store: s, mint: mint, maxt: maxt}, nil
+}
+
+type querier struct {
+	store *Store
+	mint  int64
+	maxt  int64
+}
+
+// matching returns the series matching all matchers with samples between mint and maxt.
+func (q *querier) matching(matchers []*labels.Matcher) []*series {
+	matched := make([]*series, 0)
+	for _, current := range q.store.sorted {
+		if len(current.samples) == 0 || current.samples[0].T() > q.maxt || current.samples[len(current.samples)-1].T() < q.mint {
+			continue
+		}
+		ok := true
+		for _, matcher := range matchers {
+			if !matcher.Matches(current.labels.Get(matcher.Name)) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matched = append(matched, current)
+		}
+	}
+	return matched
+}
+
+// Select returns series sorted by labels, regardless of sortSeries.
+func (q *querier) Select(sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	mint, maxt := q.mint, q.maxt
+	if hints != nil {
+		mint, maxt = hints.Start, hints.End
+	}
+	selected := make([]storage.Series, 0)
+	for _, current := range q.matching(matchers) {
+		from := sort.Search(len(current.samples), func(i int) bool { return current.samples[i].T() >= mint })
+		to := sort.Search(len(current.samples), func(i int) bool { return current.samples[i].T() > maxt })
+		if from >= to {
+			continue
+		}
+		selected = append(selected, storage.NewListSeries(current.labels, current.samples[from:to]))
+	}
+	return &seriesSet{series: selected, index: -1}
+}
+
+func (q *querier) LabelValues(name string, matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	seen := make(map[string]struct{})
+	values := make([]string, 0)
+	for _, current := range q.matching(matchers) {
+		value := current.labels.Get(name)
+		if _, ok := seen[value]; !ok && value != "" {
+			seen[value] = struct{}{}
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+	return values, nil, nil
+}
+
+func (q *querier) LabelNames(matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	seen := make(map[string]struct{})
+	names := make([]string, 0)
+	for _, current := range q.matching(matchers) {
+		current.labels.Range(func(l labels.Label) {
+			if _, ok := seen[l.Name]; !ok {
+				seen[l.Name] = struct{}{}
+				names = append(names, l.Name)
+			}
+		})
+	}
+	sort.Strings(names)
+	return names, nil, nil
+}
+
+func (q *querier) Close() error {
+	return nil
+}
+
+type seriesSet struct {
+	series []storage.Series
+	index  int
+}
+
+func (s *seriesSet) Next() bool {
+	s.index++
+	return s.index < len(s.series)
+}
+
+func (s *seriesSet) At() storage.Series {
+	return s.series[s.index]
+}
+
+func (s *seriesSet) Err() error {
+	return nil
+}
+
+func (s *seriesSet) Warnings() storage.Warnings {
+	return nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+)
+
+func stream(name string, pairs ...float64) *prommodel.SampleStream {
+	s := &prommodel.SampleStream{Metric: prommodel.Metric{prommodel.MetricNameLabel: prommodel.LabelValue(name), "job": "node"}}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		s.Values = append(s.Values, prommodel.SamplePair{Timestamp: prommodel.Time(pairs[i]), Value: prommodel.SampleValue(pairs[i+1])})
+	}
+	return s
+}
+
+func TestStoreMergesDumps(t *testing.T) {
+	s := New()
+	s.Add(prommodel.Matrix{stream("up", 3000, 1, 1000, 1), stream("b", 1000, 5)})
+	s.Add(prommodel.Matrix{stream("up", 2000, 0, 3000, 0), stream("a", 500, 2)})
+	s.Sort()
+
+	if s.Series() != 3 {
+		t.Errorf("expected 3 series, got %d", s.Series())
+	}
+	if names := s.MetricNames(); !reflect.DeepEqual(names, []string{"a", "b", "up"}) {
+		t.Errorf("unexpected metric names %v", names)
+	}
+	start, end := s.Timerange()
+	if !start.Equal(time.UnixMilli(500)) || !end.Equal(time.UnixMilli(3000)) {
+		t.Errorf("unexpected time range %s - %s", start, end)
+	}
+	for _, current := range s.sorted {
+		if current.labels.Get("__name__") != "up" {
+			continue
+		}
+		expected := []sample{{t: 1000, f: 1}, {t: 2000, f: 0}, {t: 3000, f: 0}}
+		if len(current.samples) != len(expected) {
+			t.Fatalf("unexpected samples %v", current.samples)
+		}
+		for i, smpl := range current.samples {
+			if smpl != expected[i] {
+				t.Errorf("sample %d is %v, want %v", i, smpl, expected[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2023 SAP SE
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	prommodel "github.com/prometheus/common/model"
+	"github.com/sapcc/promdump/model"
+	"github.com/sapcc/promdump/query"
+)
+
+const (
+	targetStateActive  = "active"
+	targetStateDropped = "dropped"
+	targetStateAny     = "any"
+)
+
+type targetsConfig struct {
+	catalogConfig
+	// state is active, dropped or any like the state parameter of the api
+	state string
+	// health only filters active targets, dropped targets have no health
+	health []string
+	jobs   []string
+	// metadata adds the number of metrics with metadata of each target
+	metadata bool
+}
+
+// targets writes one row per scrape target matching the filters.
+func targets(ctx context.Context, w io.Writer, cfg targetsConfig) error {
+	if cfg.state != targetStateActive && cfg.state != targetStateDropped && cfg.state != targetStateAny {
+		return fmt.Errorf("unknown target state %q, must be active, dropped or any", cfg.state)
+	}
+	if cfg.state == targetStateDropped && len(cfg.health) > 0 {
+		return fmt.Errorf("--health only applies to active targets, dropped targets have no health")
+	}
+	out, httpClient, err := cfg.open(w, "targets")
+	if err != nil {
+		return err
+	}
+	defer out.abort()
+	perTarget, err := query.Targets(ctx, cfg.promURLs, cfg.metadata, httpClient)
+	if err != nil {
+		return err
+	}
+	columns := []string{"state", "job", "instance", "scrape_pool", "scrape_url", "health", "last_error", "last_scrape",
+		"last_scrape_duration", "labels", "discovered_labels"}
+	if cfg.metadata {
+		columns = append(columns, "metrics")
+	}
+	table := model.NewTable(cfg.columns(columns...)...)
+	for _, current := range perTarget {
+		metrics := make(map[string]int64)
+		for _, metadata := range current.Metadata {
+			metrics[metadata.Target["job"]+"/"+metadata.Target["instance"]]++
+		}
+		if cfg.state != targetStateDropped {
+			for _, target := range current.Targets.Active {
+				job, instance := string(target.Labels[prommodel.JobLabel]), string(target.Labels[prommodel.InstanceLabel])
+				if !contains(cfg.jobs, job) || !contains(cfg.health, string(target.Health)) {
+					continue
+				}
+				row := map[string]interface{}{
+					"server":               current.Target.Alias,
+					"state":                targetStateActive,
+					"job":                  job,
+					"instance":             instance,
+					"scrape_pool":          target.ScrapePool,
+					"scrape_url":           target.ScrapeURL,
+					"health":               string(target.Health),
+					"last_error":           target.LastError,
+					"last_scrape":          formatTime(target.LastScrape),
+					"last_scrape_duration": target.LastScrapeDuration,
+					"labels":               labelMap(target.Labels),
+					"discovered_labels":    target.DiscoveredLabels,
+				}
+				if cfg.metadata {
+					row["metrics"] = metrics[job+"/"+instance]
+				}
+				table.Add(row)
+			}
+		}
+		if cfg.state != targetStateActive {
+			for _, target := range current.Targets.Dropped {
+				// dropped targets were never relabeled, so job and instance are taken from the discovered labels
+				job := target.DiscoveredLabels[prommodel.JobLabel]
+				if !contains(cfg.jobs, job) {
+					continue
+				}
+				table.Add(map[string]interface{}{
+					"server":            current.Target.Alias,
+					"state":             targetStateDropped,
+					"job":               job,
+					"instance":          target.DiscoveredLabels[prommodel.AddressLabel],
+					"discovered_labels": target.DiscoveredLabels,
+				})
+			}
+		}
+	}
+	return out.write(table, cfg.promURLs)
+}
+
+// contains reports whether value is in list, an empty list contains everything.
+func contains(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, entry := range list {
+		if entry == value {
+			return true
+		}
+	}
+	return false
+}